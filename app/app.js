@@ -1,4 +1,22 @@
 document.addEventListener('DOMContentLoaded', async () => {
+  // SSO logins come back with the tokens in the URL fragment.
+  // Accounts with two-factor authentication come back with a challenge
+  // instead.
+  const fragment = new URLSearchParams(window.location.hash.slice(1));
+  if (fragment.get('token') || fragment.get('challenge_token')) {
+    history.replaceState(null, '', window.location.pathname);
+  }
+  if (fragment.get('token')) {
+    localStorage.setItem('token', fragment.get('token'));
+  } else if (fragment.get('challenge_token')) {
+    try {
+      const data = await completeMFALogin(fragment.get('challenge_token'));
+      localStorage.setItem('token', data.token);
+    } catch (error) {
+      alert(`Error: ${error.message}`);
+    }
+  }
+
   const token = localStorage.getItem('token');
 
   if (token) {
@@ -61,11 +79,15 @@ async function login() {
       },
       body: JSON.stringify({ email, password }),
     });
-    const data = await res.json();
+    let data = await res.json();
     if (!res.ok) {
       throw new Error(`Failed to login: ${data.error}`);
     }
 
+    if (data.mfa_required) {
+      data = await completeMFALogin(data.challenge_token);
+    }
+
     if (data.token) {
       localStorage.setItem('token', data.token);
       document.getElementById('auth-section').style.display = 'none';
@@ -79,6 +101,37 @@ async function login() {
   }
 }
 
+async function completeMFALogin(challengeToken) {
+  const code = prompt('Enter the code from your authenticator app, or a recovery code:');
+  if (!code) {
+    throw new Error('Two-factor code is required');
+  }
+
+  const body = { challenge_token: challengeToken };
+  if (/^\d{6}$/.test(code.trim())) {
+    body.code = code.trim();
+  } else {
+    body.recovery_code = code;
+  }
+
+  const res = await fetch('/api/login/mfa', {
+    method: 'POST',
+    headers: {
+      'Content-Type': 'application/json',
+    },
+    body: JSON.stringify(body),
+  });
+  const data = await res.json();
+  if (!res.ok) {
+    throw new Error(`Failed to login: ${data.error}`);
+  }
+  return data;
+}
+
+function loginWithSSO() {
+  window.location.href = '/api/oidc/login';
+}
+
 async function signup() {
   const email = document.getElementById('email').value;
   const password = document.getElementById('password').value;
@@ -1,13 +1,20 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"mime"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 )
 
 func (cfg apiConfig) ensureAssetsDir() error {
@@ -56,6 +63,49 @@ func (cfg apiConfig) getS3AssetURL(assetKey string) string {
 	return fmt.Sprintf("%s/%s", cfg.s3CfDistribution, assetKey)
 }
 
+// thumbnailAssetFilename returns the file in the assets directory a
+// thumbnail URL points to, if it points into it.
+func (cfg apiConfig) thumbnailAssetFilename(thumbnailURL string) (string, bool) {
+	assetFilename, ok := strings.CutPrefix(thumbnailURL, cfg.getAssetURL(""))
+	return assetFilename, ok && assetFilename != "" && assetFilename == filepath.Base(assetFilename)
+}
+
+// videoAssetKey returns the S3 key a video URL points to, if it points into
+// the bucket.
+func (cfg apiConfig) videoAssetKey(videoURL string) (string, bool) {
+	assetKey, ok := strings.CutPrefix(videoURL, cfg.getS3AssetURL(""))
+	return assetKey, ok && assetKey != ""
+}
+
+// deleteVideoAssets removes a video's thumbnail from the assets directory
+// and its video file from S3. Missing assets are not an error.
+func (cfg apiConfig) deleteVideoAssets(ctx context.Context, video database.Video) error {
+	if video.ThumbnailURL != nil {
+		if assetFilename, ok := cfg.thumbnailAssetFilename(*video.ThumbnailURL); ok {
+			err := os.Remove(cfg.getAssetDiskPath(assetFilename))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("error removing thumbnail '%s': %w", assetFilename, err)
+			}
+		}
+	}
+
+	if video.VideoURL != nil {
+		if assetKey, ok := cfg.videoAssetKey(*video.VideoURL); ok {
+			start := time.Now()
+			_, err := cfg.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+				Bucket: aws.String(cfg.s3Bucket),
+				Key:    aws.String(assetKey),
+			})
+			observeS3Request("DeleteObject", start, err)
+			if err != nil {
+				return fmt.Errorf("error deleting video '%s' from S3: %w", assetKey, err)
+			}
+		}
+	}
+
+	return nil
+}
+
 func mediaTypeToExt(mediaType string) string {
 	parts := strings.Split(mediaType, "/")
 	if len(parts) != 2 {
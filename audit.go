@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	auditUserLogin        = "user.login"
+	auditUserCreate       = "user.create"
+	auditUserDelete       = "user.delete"
+	auditPasswordChange   = "user.password_change"
+	auditPasswordReset    = "user.password_reset"
+	auditTOTPEnable       = "user.totp_enable"
+	auditTOTPDisable      = "user.totp_disable"
+	auditSessionRefresh   = "session.refresh"
+	auditSessionRevoke    = "session.revoke"
+	auditSessionsRevoke   = "session.revoke_all"
+	auditVideoCreate      = "video.create"
+	auditVideoUpdate      = "video.update"
+	auditVideoDelete      = "video.delete"
+	auditVideoRestore     = "video.restore"
+	auditVideoUpload      = "video.upload"
+	auditThumbnailUpload  = "video.thumbnail_upload"
+	auditAdminRole        = "admin.role_change"
+	auditAdminQuota       = "admin.quota_change"
+	auditAdminDisable     = "admin.user_disable"
+	auditAdminEnable      = "admin.user_enable"
+	auditAdminReset       = "admin.password_reset"
+	auditAdminImpersonate = "admin.impersonate"
+
+	auditTargetUser    = "user"
+	auditTargetSession = "session"
+	auditTargetVideo   = "video"
+)
+
+// auditEvent describes an action by actorID, or by an unknown actor if it's
+// uuid.Nil, on a target.
+func auditEvent(action string, outcome database.AuditOutcome, actorID uuid.UUID, targetType string, targetID uuid.UUID) database.CreateAuditEventParams {
+	event := database.CreateAuditEventParams{
+		Action:     action,
+		Outcome:    outcome,
+		TargetType: targetType,
+	}
+	if actorID != uuid.Nil {
+		event.ActorID = &actorID
+	}
+	if targetID != uuid.Nil {
+		event.TargetID = targetID.String()
+	}
+	return event
+}
+
+// audit records an event, filling in where the request came from and, for
+// impersonation tokens, which admin was behind it. A failure to record the
+// event is logged but doesn't fail the request.
+func (cfg *apiConfig) audit(r *http.Request, event database.CreateAuditEventParams) {
+	event.IP = clientIP(r)
+	event.UserAgent = r.UserAgent()
+	if token, err := auth.GetBearerToken(r.Header); err == nil {
+		impersonator, err := auth.GetImpersonator(token, cfg.jwtKeys)
+		if err == nil && impersonator != uuid.Nil {
+			event.ImpersonatorID = &impersonator
+		}
+	}
+
+	err := cfg.db.WithContext(r.Context()).CreateAuditEvent(event)
+	if err != nil {
+		requestLogger(r).Error("Couldn't record audit event", "action", event.Action, "error", err)
+	}
+}
+
+// auditCommand records an event for something an operator did with one of
+// the tubely commands. There's no actor or IP; the user agent names the
+// command instead.
+func (cfg *apiConfig) auditCommand(ctx context.Context, command string, event database.CreateAuditEventParams) {
+	event.UserAgent = "tubely " + command
+	err := cfg.db.WithContext(ctx).CreateAuditEvent(event)
+	if err != nil {
+		cfg.logger.Error("Couldn't record audit event", "action", event.Action, "error", err)
+	}
+}
+
+// auditLogin describes a login attempt on the user's account, or on no
+// account if userID is uuid.Nil. Only a successful login has an actor, since
+// until then nobody has proven who they are.
+func auditLogin(outcome database.AuditOutcome, userID uuid.UUID, detail string) database.CreateAuditEventParams {
+	actorID := uuid.Nil
+	if outcome == database.AuditOutcomeSuccess {
+		actorID = userID
+	}
+	event := auditEvent(auditUserLogin, outcome, actorID, auditTargetUser, userID)
+	event.Detail = detail
+	return event
+}
+
+// auditRefreshToken records an action on the session a refresh token belongs
+// to, attributing it to the session's owner. The token itself is never
+// recorded.
+func (cfg *apiConfig) auditRefreshToken(r *http.Request, action string, outcome database.AuditOutcome, refreshToken, detail string) {
+	session, err := cfg.db.WithContext(r.Context()).GetRefreshToken(refreshToken)
+	if err != nil {
+		requestLogger(r).Error("Couldn't look up session for audit event", "action", action, "error", err)
+	}
+	event := auditEvent(action, outcome, session.UserID, auditTargetSession, session.ID)
+	event.Detail = detail
+	cfg.audit(r, event)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// requireRole authenticates the request and checks the user has at least the
+// given role. On failure it writes the error response and returns false.
+func (cfg *apiConfig) requireRole(w http.ResponseWriter, r *http.Request, role database.Role) (database.User, bool) {
+	user, ok := cfg.getAuthenticatedUser(w, r)
+	if !ok {
+		return database.User{}, false
+	}
+	if !user.Role.Includes(role) {
+		respondWithError(w, http.StatusForbidden, "You don't have permission to do that", nil)
+		return database.User{}, false
+	}
+	return user, true
+}
+
+// rejectImpersonation refuses requests made with an impersonation token, for
+// endpoints that change the user's credentials or sessions. On failure it
+// writes the error response and returns false.
+func (cfg *apiConfig) rejectImpersonation(w http.ResponseWriter, r *http.Request) bool {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return false
+	}
+	impersonator, err := auth.GetImpersonator(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return false
+	}
+	if impersonator != uuid.Nil {
+		respondWithError(w, http.StatusForbidden, "Not allowed while impersonating a user", nil)
+		return false
+	}
+	return true
+}
+
+// rejectDisabledUsers refuses requests carrying the access token of a
+// disabled account. Access tokens outlive the refresh tokens revoked when an
+// account is disabled, so every authenticated request has to be checked.
+func (cfg *apiConfig) rejectDisabledUsers(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Refresh tokens and anything else that isn't an access token are
+		// left for the handler to deal with.
+		userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Every access token is checked here, so this is where the user
+		// joins the request's log fields.
+		setLogUserID(r, userID)
+
+		user, err := cfg.db.WithContext(r.Context()).GetUser(userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+			return
+		}
+		if user != nil && user.DisabledAt != nil {
+			respondWithError(w, http.StatusForbidden, "Account disabled", nil)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,143 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// MFARequiredError is returned by Login for accounts with two-factor
+// authentication enabled. Finish logging in with LoginMFA or
+// LoginRecoveryCode.
+type MFARequiredError struct {
+	ChallengeToken string
+}
+
+func (e *MFARequiredError) Error() string {
+	return "tubely: two-factor authentication code required"
+}
+
+// ErrNotLoggedIn is returned when a request needs a refresh token and the
+// client doesn't have one.
+var ErrNotLoggedIn = errors.New("tubely: not logged in")
+
+// CreateUser signs up a new user. It doesn't log in as them.
+func (c *Client) CreateUser(ctx context.Context, email, password string) (User, error) {
+	body, err := jsonBody(map[string]string{
+		"email":    email,
+		"password": password,
+	})
+	if err != nil {
+		return User{}, err
+	}
+	var user User
+	err = c.sendUnauthenticated(ctx, "/api/users", body, "", &user)
+	return user, err
+}
+
+// Login starts a session as the user. If the account has two-factor
+// authentication enabled it returns an *MFARequiredError instead.
+func (c *Client) Login(ctx context.Context, email, password string) (User, error) {
+	return c.login(ctx, "/api/login", map[string]string{
+		"email":    email,
+		"password": password,
+	})
+}
+
+// LoginMFA finishes a two-factor login with a code from the user's
+// authenticator app.
+func (c *Client) LoginMFA(ctx context.Context, challengeToken, code string) (User, error) {
+	return c.login(ctx, "/api/login/mfa", map[string]string{
+		"challenge_token": challengeToken,
+		"code":            code,
+	})
+}
+
+// LoginRecoveryCode finishes a two-factor login with one of the user's
+// recovery codes.
+func (c *Client) LoginRecoveryCode(ctx context.Context, challengeToken, recoveryCode string) (User, error) {
+	return c.login(ctx, "/api/login/mfa", map[string]string{
+		"challenge_token": challengeToken,
+		"recovery_code":   recoveryCode,
+	})
+}
+
+func (c *Client) login(ctx context.Context, path string, params map[string]string) (User, error) {
+	type response struct {
+		User
+		Tokens
+		MFARequired    bool   `json:"mfa_required"`
+		ChallengeToken string `json:"challenge_token"`
+	}
+
+	body, err := jsonBody(params)
+	if err != nil {
+		return User{}, err
+	}
+	var resp response
+	if err := c.sendUnauthenticated(ctx, path, body, "", &resp); err != nil {
+		return User{}, err
+	}
+	if resp.MFARequired {
+		return User{}, &MFARequiredError{ChallengeToken: resp.ChallengeToken}
+	}
+	c.setTokens(resp.Tokens)
+	return resp.User, nil
+}
+
+// Refresh exchanges the refresh token for a new access token. Requests do
+// this on their own when the access token expires, so it's rarely needed.
+func (c *Client) Refresh(ctx context.Context) error {
+	return c.refresh(ctx, c.Tokens().AccessToken)
+}
+
+// refresh replaces the access token stale with a new one, unless another
+// request has already done so.
+func (c *Client) refresh(ctx context.Context, stale string) error {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	tokens := c.Tokens()
+	if tokens.AccessToken != stale {
+		return nil
+	}
+	if tokens.RefreshToken == "" {
+		return ErrNotLoggedIn
+	}
+
+	var resp struct {
+		Token string `json:"token"`
+	}
+	if err := c.sendUnauthenticated(ctx, "/api/refresh", nil, tokens.RefreshToken, &resp); err != nil {
+		return fmt.Errorf("couldn't refresh access token: %w", err)
+	}
+	tokens.AccessToken = resp.Token
+	c.setTokens(tokens)
+	return nil
+}
+
+// Logout revokes the session's refresh token and forgets both tokens.
+func (c *Client) Logout(ctx context.Context) error {
+	tokens := c.Tokens()
+	if tokens.RefreshToken == "" {
+		return ErrNotLoggedIn
+	}
+	if err := c.sendUnauthenticated(ctx, "/api/revoke", nil, tokens.RefreshToken, nil); err != nil {
+		return err
+	}
+	c.setTokens(Tokens{})
+	return nil
+}
+
+// sendUnauthenticated POSTs to one of the endpoints that hand out tokens,
+// which are never retried with a refreshed one. token, if set, is sent as
+// the bearer token.
+func (c *Client) sendUnauthenticated(ctx context.Context, path string, body *requestBody, token string, out any) error {
+	resp, err := c.send(ctx, http.MethodPost, path, body, token)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return decodeResponse(resp, out)
+}
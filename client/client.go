@@ -0,0 +1,266 @@
+// Package client is a Go client for the Tubely API.
+//
+// A Client logs in once and then keeps its access token fresh on its own:
+// it refreshes the token shortly before it expires, and again if the server
+// rejects it, retrying the request that failed.
+//
+//	c := client.New("http://localhost:8091", nil)
+//	if _, err := c.Login(ctx, email, password); err != nil {
+//		return err
+//	}
+//	video, err := c.CreateVideo(ctx, "Boots", "A video about boots")
+//	if err != nil {
+//		return err
+//	}
+//	video, err = c.UploadVideoFile(ctx, video.ID, "boots.mp4", nil)
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/api"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Video and User are the shapes the API responds with.
+type (
+	Video = api.Video
+	User  = api.User
+)
+
+// refreshBefore is how long before an access token expires it's refreshed.
+const refreshBefore = time.Minute
+
+const userAgent = "tubely-go-client"
+
+// Tokens are a logged in session's credentials.
+type Tokens struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Client calls the Tubely API. It's safe for concurrent use.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+
+	mu       sync.Mutex
+	tokens   Tokens
+	onTokens func(Tokens)
+	// refreshMu is held while refreshing the access token, so concurrent
+	// requests that find it expired only refresh it once.
+	refreshMu sync.Mutex
+}
+
+// New returns a client for the server at baseURL, such as
+// "https://tubely.example.com". It uses http.DefaultClient if httpClient is
+// nil.
+func New(baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: httpClient,
+	}
+}
+
+// SetTokens resumes a session, such as one saved from an earlier run. The
+// access token may be empty if only the refresh token was kept.
+func (c *Client) SetTokens(tokens Tokens) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tokens = tokens
+}
+
+// Tokens returns the session's current credentials.
+func (c *Client) Tokens() Tokens {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tokens
+}
+
+// OnTokens registers fn to be called whenever the client logs in or
+// refreshes its access token, so the new tokens can be saved.
+func (c *Client) OnTokens(fn func(Tokens)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onTokens = fn
+}
+
+func (c *Client) setTokens(tokens Tokens) {
+	c.mu.Lock()
+	c.tokens = tokens
+	onTokens := c.onTokens
+	c.mu.Unlock()
+	if onTokens != nil {
+		onTokens(tokens)
+	}
+}
+
+// APIError is an error response from the server.
+type APIError struct {
+	StatusCode int
+	Message    string
+	// RetryAfter is how long the server asked to wait before trying again,
+	// when it's rate limiting or the account is locked.
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("tubely: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("tubely: %d %s", e.StatusCode, e.Message)
+}
+
+// IsStatus reports whether err is an APIError with the given status code.
+func IsStatus(err error, statusCode int) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == statusCode
+}
+
+// requestBody is the body of a request. open is called again to send the
+// body a second time if the request is retried after refreshing the access
+// token; bodies that can't be replayed return errNotReplayable then. The
+// length is -1 if it's unknown.
+type requestBody struct {
+	contentType string
+	open        func() (io.Reader, int64, error)
+}
+
+var errNotReplayable = errors.New("request body can't be sent again")
+
+func jsonBody(v any) (*requestBody, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return &requestBody{
+		contentType: "application/json",
+		open: func() (io.Reader, int64, error) {
+			return bytes.NewReader(data), int64(len(data)), nil
+		},
+	}, nil
+}
+
+// do sends an authenticated request and decodes a successful response into
+// out, if it's not nil. If the access token is rejected it's refreshed and
+// the request sent again, as long as its body can be.
+func (c *Client) do(ctx context.Context, method, path string, body *requestBody, out any) error {
+	token, err := c.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	resp, err := c.send(ctx, method, path, body, token)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized && c.Tokens().RefreshToken != "" {
+		rejected := decodeResponse(resp, nil)
+		resp.Body.Close()
+		if err := c.refresh(ctx, token); err != nil {
+			return err
+		}
+		resp, err = c.send(ctx, method, path, body, c.Tokens().AccessToken)
+		if errors.Is(err, errNotReplayable) {
+			return rejected
+		}
+		if err != nil {
+			return err
+		}
+	}
+	defer resp.Body.Close()
+	return decodeResponse(resp, out)
+}
+
+// send makes a single request, authenticated with token if it's not empty.
+func (c *Client) send(ctx context.Context, method, path string, body *requestBody, token string) (*http.Response, error) {
+	var reader io.Reader
+	length := int64(-1)
+	if body != nil {
+		var err error
+		reader, length, err = body.open()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", body.contentType)
+		if length >= 0 {
+			req.ContentLength = length
+		}
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return c.httpClient.Do(req)
+}
+
+func decodeResponse(resp *http.Response, out any) error {
+	if resp.StatusCode >= 300 {
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		var errResp struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&errResp) == nil {
+			apiErr.Message = errResp.Error
+		}
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			apiErr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+		return apiErr
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("couldn't decode response: %w", err)
+	}
+	return nil
+}
+
+// accessToken returns an access token to send, refreshing it first if it's
+// missing or about to expire and there's a refresh token to do that with.
+func (c *Client) accessToken(ctx context.Context) (string, error) {
+	tokens := c.Tokens()
+	if tokens.RefreshToken == "" || !expiresSoon(tokens.AccessToken) {
+		return tokens.AccessToken, nil
+	}
+	if err := c.refresh(ctx, tokens.AccessToken); err != nil {
+		return "", err
+	}
+	return c.Tokens().AccessToken, nil
+}
+
+// expiresSoon reports whether the access token is missing or expires within
+// refreshBefore. The token is only read, not verified; that's the server's
+// job.
+func expiresSoon(token string) bool {
+	if token == "" {
+		return true
+	}
+	claims := jwt.RegisteredClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, &claims); err != nil {
+		return false
+	}
+	return claims.ExpiresAt != nil && time.Until(claims.ExpiresAt.Time) < refreshBefore
+}
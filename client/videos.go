@@ -0,0 +1,199 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// CreateVideo creates a draft video, ready for its thumbnail and video file
+// to be uploaded.
+func (c *Client) CreateVideo(ctx context.Context, title, description string) (Video, error) {
+	body, err := jsonBody(map[string]string{
+		"title":       title,
+		"description": description,
+	})
+	if err != nil {
+		return Video{}, err
+	}
+	var video Video
+	err = c.do(ctx, http.MethodPost, "/api/videos", body, &video)
+	return video, err
+}
+
+// GetVideo returns one of the user's videos.
+func (c *Client) GetVideo(ctx context.Context, videoID uuid.UUID) (Video, error) {
+	var video Video
+	err := c.do(ctx, http.MethodGet, "/api/videos/"+videoID.String(), nil, &video)
+	return video, err
+}
+
+// ListVideos returns all of the user's videos, newest first.
+func (c *Client) ListVideos(ctx context.Context) ([]Video, error) {
+	var videos []Video
+	err := c.do(ctx, http.MethodGet, "/api/videos", nil, &videos)
+	return videos, err
+}
+
+// DeleteVideo moves a video to the trash.
+func (c *Client) DeleteVideo(ctx context.Context, videoID uuid.UUID) error {
+	return c.do(ctx, http.MethodDelete, "/api/videos/"+videoID.String(), nil, nil)
+}
+
+// ProgressFunc is told how many bytes of an upload have been sent so far,
+// out of total. total is -1 if the size isn't known.
+type ProgressFunc func(sent, total int64)
+
+// Upload is a file to upload.
+type Upload struct {
+	Filename string
+	// ContentType is the file's media type. The server accepts image/jpeg
+	// and image/png thumbnails and video/mp4 videos.
+	ContentType string
+	// Body is the file's contents. If it's also an io.Seeker the upload can
+	// be retried after refreshing an expired access token.
+	Body io.Reader
+	// Size is the length of Body, or 0 if it's unknown.
+	Size int64
+	// Progress, if not nil, is called as the file is sent.
+	Progress ProgressFunc
+}
+
+// UploadThumbnail sets a video's thumbnail.
+func (c *Client) UploadThumbnail(ctx context.Context, videoID uuid.UUID, upload Upload) (Video, error) {
+	var video Video
+	err := c.do(ctx, http.MethodPost, "/api/thumbnail_upload/"+videoID.String(), multipartBody("thumbnail", upload), &video)
+	return video, err
+}
+
+// UploadVideo uploads a video's file, replacing any earlier one.
+func (c *Client) UploadVideo(ctx context.Context, videoID uuid.UUID, upload Upload) (Video, error) {
+	var video Video
+	err := c.do(ctx, http.MethodPost, "/api/video_upload/"+videoID.String(), multipartBody("video", upload), &video)
+	return video, err
+}
+
+// UploadThumbnailFile uploads the image at path as a video's thumbnail.
+func (c *Client) UploadThumbnailFile(ctx context.Context, videoID uuid.UUID, path string, progress ProgressFunc) (Video, error) {
+	return uploadFile(path, progress, func(upload Upload) (Video, error) {
+		return c.UploadThumbnail(ctx, videoID, upload)
+	})
+}
+
+// UploadVideoFile uploads the MP4 file at path as a video's file.
+func (c *Client) UploadVideoFile(ctx context.Context, videoID uuid.UUID, path string, progress ProgressFunc) (Video, error) {
+	return uploadFile(path, progress, func(upload Upload) (Video, error) {
+		return c.UploadVideo(ctx, videoID, upload)
+	})
+}
+
+// contentTypes are the media types of the files the server accepts, by
+// extension.
+var contentTypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".mp4":  "video/mp4",
+}
+
+func uploadFile(path string, progress ProgressFunc, send func(Upload) (Video, error)) (Video, error) {
+	contentType, ok := contentTypes[strings.ToLower(filepath.Ext(path))]
+	if !ok {
+		return Video{}, fmt.Errorf("%s isn't a .jpg, .png or .mp4 file", path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return Video{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return Video{}, err
+	}
+
+	return send(Upload{
+		Filename:    filepath.Base(path),
+		ContentType: contentType,
+		Body:        f,
+		Size:        info.Size(),
+		Progress:    progress,
+	})
+}
+
+// multipartBody sends upload as the form's only field. The multipart
+// framing is built up front so the request can have a Content-Length when
+// the file's size is known, and the file itself is streamed.
+func multipartBody(field string, upload Upload) *requestBody {
+	var framing bytes.Buffer
+	mw := multipart.NewWriter(&framing)
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, field, escapeQuotes(upload.Filename)))
+	header.Set("Content-Type", upload.ContentType)
+	// Writing to a bytes.Buffer can't fail.
+	mw.CreatePart(header)
+	prefix := bytes.Clone(framing.Bytes())
+	framing.Reset()
+	mw.Close()
+	suffix := bytes.Clone(framing.Bytes())
+
+	opened := false
+	return &requestBody{
+		contentType: mw.FormDataContentType(),
+		open: func() (io.Reader, int64, error) {
+			if opened {
+				seeker, ok := upload.Body.(io.Seeker)
+				if !ok {
+					return nil, 0, errNotReplayable
+				}
+				if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+					return nil, 0, errors.Join(errNotReplayable, err)
+				}
+			}
+			opened = true
+
+			length, total := int64(-1), int64(-1)
+			if upload.Size > 0 {
+				length = int64(len(prefix)) + upload.Size + int64(len(suffix))
+				total = upload.Size
+			}
+			body := io.Reader(upload.Body)
+			if upload.Progress != nil {
+				body = &progressReader{r: body, total: total, progress: upload.Progress}
+			}
+			return io.MultiReader(bytes.NewReader(prefix), body, bytes.NewReader(suffix)), length, nil
+		},
+	}
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
+
+type progressReader struct {
+	r        io.Reader
+	sent     int64
+	total    int64
+	progress ProgressFunc
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.sent += int64(n)
+		r.progress(r.sent, r.total)
+	}
+	return n, err
+}
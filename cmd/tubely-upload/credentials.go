@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// credentials are the saved session. Only the refresh token is kept; the
+// client exchanges it for an access token when it first needs one.
+type credentials struct {
+	Server       string `json:"server"`
+	Email        string `json:"email"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func defaultCredentialsPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("couldn't find a directory to save the session in, set TUBELY_CREDENTIALS: %w", err)
+	}
+	return filepath.Join(dir, "tubely", "credentials.json"), nil
+}
+
+// loadCredentials reads the saved session, returning none if there isn't
+// one.
+func loadCredentials(path string) (credentials, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return credentials{}, nil
+	}
+	if err != nil {
+		return credentials{}, fmt.Errorf("couldn't read saved session: %w", err)
+	}
+	var creds credentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return credentials{}, fmt.Errorf("couldn't parse saved session in %s: %w", path, err)
+	}
+	return creds, nil
+}
+
+// saveCredentials writes the session where only the current user can read
+// it, since the refresh token is as good as a password.
+func saveCredentials(path string, creds credentials) error {
+	data, err := json.MarshalIndent(creds, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("couldn't save session: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".credentials-*")
+	if err != nil {
+		return fmt.Errorf("couldn't save session: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(append(data, '\n'))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't save session: %w", err)
+	}
+	return nil
+}
+
+func removeCredentials(path string) error {
+	err := os.Remove(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("couldn't remove saved session: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/client"
+	"golang.org/x/term"
+)
+
+func commandLogin(ctx context.Context, env *environment, flags *flag.FlagSet, args []string) error {
+	email := flags.String("email", "", "email to log in with; asked for if not given")
+	passwordStdin := flags.Bool("password-stdin", false, "read the password from stdin instead of asking for it")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return errUsage
+	}
+
+	stdin := bufio.NewReader(os.Stdin)
+	if *email == "" {
+		var err error
+		if *email, err = prompt(stdin, "Email: "); err != nil {
+			return err
+		}
+	}
+	var password string
+	var err error
+	if *passwordStdin {
+		password, err = readLine(stdin)
+	} else {
+		password, err = promptSecret(stdin, "Password: ")
+	}
+	if err != nil {
+		return err
+	}
+
+	user, err := env.client.Login(ctx, *email, password)
+	var mfaErr *client.MFARequiredError
+	if errors.As(err, &mfaErr) {
+		code, promptErr := prompt(stdin, "Authentication or recovery code: ")
+		if promptErr != nil {
+			return promptErr
+		}
+		if isTOTPCode(code) {
+			user, err = env.client.LoginMFA(ctx, mfaErr.ChallengeToken, code)
+		} else {
+			user, err = env.client.LoginRecoveryCode(ctx, mfaErr.ChallengeToken, code)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't log in: %w", err)
+	}
+
+	err = saveCredentials(env.credentialsPath, credentials{
+		Server:       env.server,
+		Email:        user.Email,
+		RefreshToken: env.client.Tokens().RefreshToken,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Logged in to %s as %s\n", env.server, user.Email)
+	return nil
+}
+
+func commandLogout(ctx context.Context, env *environment, flags *flag.FlagSet, args []string) error {
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return errUsage
+	}
+	if err := env.requireLogin(); err != nil {
+		return err
+	}
+
+	err := env.client.Logout(ctx)
+	// A session the server no longer knows about is as good as logged out.
+	if err != nil && !client.IsStatus(err, http.StatusUnauthorized) {
+		return fmt.Errorf("couldn't log out: %w", err)
+	}
+	if err := removeCredentials(env.credentialsPath); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Logged out of %s\n", env.server)
+	return nil
+}
+
+// isTOTPCode tells a six digit authenticator code from a recovery code.
+func isTOTPCode(code string) bool {
+	return len(code) == 6 && strings.Trim(code, "0123456789") == ""
+}
+
+func prompt(stdin *bufio.Reader, label string) (string, error) {
+	fmt.Fprint(os.Stderr, label)
+	line, err := readLine(stdin)
+	return strings.TrimSpace(line), err
+}
+
+// promptSecret asks for a value without echoing it, when stdin is a
+// terminal.
+func promptSecret(stdin *bufio.Reader, label string) (string, error) {
+	fmt.Fprint(os.Stderr, label)
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return readLine(stdin)
+	}
+	secret, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("couldn't read %s: %w", strings.ToLower(strings.TrimSuffix(label, ": ")), err)
+	}
+	return string(secret), nil
+}
+
+func readLine(stdin *bufio.Reader) (string, error) {
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("couldn't read input: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,196 @@
+// Command tubely-upload uploads videos to a Tubely server from the command
+// line.
+//
+// Log in once, which saves a refresh token, then upload a directory of MP4
+// files:
+//
+//	go run ./cmd/tubely-upload --server https://tubely.example.com login --email you@example.com
+//	go run ./cmd/tubely-upload upload ./clips
+//
+// Each clip.mp4 becomes a video titled "clip", or whatever its clip.json
+// sidecar says, and clip.jpg, clip.jpeg or clip.png becomes its thumbnail.
+// Run go run ./cmd/tubely-upload -h for the full list of commands.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/client"
+)
+
+const defaultServer = "http://localhost:8091"
+
+// errUsage is returned by commands called with the wrong arguments, after
+// they've printed their usage.
+var errUsage = errors.New("usage")
+
+// command is one of the uploader's subcommands. run is given a flag set to
+// define its flags on, which prints the command's usage.
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(ctx context.Context, env *environment, flags *flag.FlagSet, args []string) error
+}
+
+// environment is what every command runs with.
+type environment struct {
+	server          string
+	credentialsPath string
+	client          *client.Client
+}
+
+var commands = []command{
+	{
+		name:    "login",
+		usage:   "[--email EMAIL] [--password-stdin]",
+		summary: "Log in and save the session for the other commands",
+		run:     commandLogin,
+	},
+	{
+		name:    "logout",
+		summary: "End the saved session",
+		run:     commandLogout,
+	},
+	{
+		name:    "upload",
+		usage:   "[--concurrency N] [--retries N] [--skip-existing] <directory>",
+		summary: "Upload every MP4 file in a directory, with matching thumbnails",
+		run:     commandUpload,
+	},
+}
+
+func main() {
+	flags := flag.NewFlagSet("tubely-upload", flag.ExitOnError)
+	flags.Usage = func() { printUsage(flags) }
+	server := flags.String("server", "", "Tubely server URL (TUBELY_SERVER, or the one logged in to, or "+defaultServer+")")
+	credentialsPath := flags.String("credentials", "", "file the session is saved in (TUBELY_CREDENTIALS)")
+	flags.Parse(os.Args[1:])
+
+	args := flags.Args()
+	if len(args) == 0 {
+		printUsage(flags)
+		os.Exit(2)
+	}
+	cmd, ok := findCommand(args[0])
+	if !ok {
+		fmt.Fprintf(os.Stderr, "tubely-upload: unknown command %q\n\n", args[0])
+		printUsage(flags)
+		os.Exit(2)
+	}
+
+	env, err := newEnvironment(*server, *credentialsPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tubely-upload: %v\n", err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err = cmd.run(ctx, env, commandFlags(cmd), args[1:])
+	stop()
+	if errors.Is(err, errUsage) {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tubely-upload: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// newEnvironment works out which server to talk to and resumes the saved
+// session if it's for that server.
+func newEnvironment(server, credentialsPath string) (*environment, error) {
+	if credentialsPath == "" {
+		credentialsPath = os.Getenv("TUBELY_CREDENTIALS")
+	}
+	if credentialsPath == "" {
+		var err error
+		credentialsPath, err = defaultCredentialsPath()
+		if err != nil {
+			return nil, err
+		}
+	}
+	creds, err := loadCredentials(credentialsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if server == "" {
+		server = os.Getenv("TUBELY_SERVER")
+	}
+	if server == "" {
+		server = creds.Server
+	}
+	if server == "" {
+		server = defaultServer
+	}
+	server = strings.TrimSuffix(server, "/")
+
+	env := &environment{
+		server:          server,
+		credentialsPath: credentialsPath,
+		client:          client.New(server, nil),
+	}
+	if creds.Server == server {
+		env.client.SetTokens(client.Tokens{RefreshToken: creds.RefreshToken})
+	}
+	return env, nil
+}
+
+// requireLogin fails unless there's a saved session for the server.
+func (env *environment) requireLogin() error {
+	if env.client.Tokens().RefreshToken == "" {
+		return fmt.Errorf("not logged in to %s, run tubely-upload login first", env.server)
+	}
+	return nil
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+func printUsage(flags *flag.FlagSet) {
+	out := flags.Output()
+	fmt.Fprintf(out, "Usage: tubely-upload [flags] <command> [command flags]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %s\n    \t%s\n", strings.TrimSpace(cmd.name+" "+cmd.usage), cmd.summary)
+	}
+	fmt.Fprintf(out, "\nFlags, which must come before the command:\n")
+	flags.PrintDefaults()
+}
+
+// commandFlags returns the flag set cmd defines its own flags on. Bad flags
+// are reported by parseFlags rather than exiting, so they exit with the
+// same status as other usage errors.
+func commandFlags(cmd command) *flag.FlagSet {
+	flags := flag.NewFlagSet("tubely-upload "+cmd.name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: tubely-upload [flags] %s %s\n\n%s.\n", cmd.name, cmd.usage, cmd.summary)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseFlags parses a command's flags, turning a bad flag into errUsage.
+func parseFlags(flags *flag.FlagSet, args []string) error {
+	err := flags.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		return errUsage
+	}
+	return nil
+}
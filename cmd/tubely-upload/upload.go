@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/client"
+	"github.com/google/uuid"
+)
+
+const (
+	retryBaseDelay = 2 * time.Second
+	retryMaxDelay  = time.Minute
+)
+
+// thumbnailExtensions are tried in order to find a video's thumbnail.
+var thumbnailExtensions = []string{".jpg", ".jpeg", ".png"}
+
+// sidecar is the optional clip.json next to clip.mp4 describing the video.
+type sidecar struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	// Thumbnail is the thumbnail's path, relative to the sidecar, for
+	// when it isn't named after the video.
+	Thumbnail string `json:"thumbnail"`
+}
+
+// uploadJob is one video file to upload, the nth of the directory's.
+type uploadJob struct {
+	n           int
+	path        string
+	thumbnail   string
+	title       string
+	description string
+}
+
+type uploadResult struct {
+	job      uploadJob
+	videoID  uuid.UUID
+	attempts int
+	// err is why the video couldn't be uploaded; thumbnailErr is why its
+	// thumbnail couldn't be, once the video was.
+	err          error
+	thumbnailErr error
+	skipped      bool
+}
+
+func commandUpload(ctx context.Context, env *environment, flags *flag.FlagSet, args []string) error {
+	concurrency := flags.Int("concurrency", 3, "number of videos to upload at once")
+	retries := flags.Int("retries", 3, "times to retry each step of an upload after a network or server error")
+	skipExisting := flags.Bool("skip-existing", false, "skip videos titled the same as one already uploaded")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 || *concurrency < 1 || *retries < 0 {
+		flags.Usage()
+		return errUsage
+	}
+	if err := env.requireLogin(); err != nil {
+		return err
+	}
+
+	jobs, err := findUploads(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if len(jobs) == 0 {
+		return fmt.Errorf("no .mp4 files in %s", flags.Arg(0))
+	}
+
+	existing := make(map[string]bool)
+	if *skipExisting {
+		videos, err := env.client.ListVideos(ctx)
+		if err != nil {
+			return fmt.Errorf("couldn't list uploaded videos: %w", err)
+		}
+		for _, video := range videos {
+			existing[video.Title] = true
+		}
+	}
+
+	u := &uploader{
+		client:  env.client,
+		retries: *retries,
+		total:   len(jobs),
+	}
+	results := make([]uploadResult, len(jobs))
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for range *concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				if existing[jobs[i].title] {
+					results[i] = uploadResult{job: jobs[i], skipped: true}
+					u.logf(jobs[i], "skipped, already uploaded")
+					continue
+				}
+				results[i] = u.upload(ctx, jobs[i])
+			}
+		}()
+	}
+	for i := range jobs {
+		select {
+		case queue <- i:
+		case <-ctx.Done():
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	return printSummary(results)
+}
+
+// findUploads lists the MP4 files in dir along with their thumbnails and
+// sidecars. It reports every broken sidecar rather than uploading only some
+// of the directory.
+func findUploads(dir string) ([]uploadJob, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			files[entry.Name()] = true
+		}
+	}
+
+	var jobs []uploadJob
+	var errs []error
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || strings.ToLower(filepath.Ext(name)) != ".mp4" {
+			continue
+		}
+		base := strings.TrimSuffix(name, filepath.Ext(name))
+		job := uploadJob{
+			n:     len(jobs) + 1,
+			path:  filepath.Join(dir, name),
+			title: titleFromFilename(base),
+		}
+		for _, ext := range thumbnailExtensions {
+			if files[base+ext] {
+				job.thumbnail = filepath.Join(dir, base+ext)
+				break
+			}
+		}
+
+		if files[base+".json"] {
+			meta, err := readSidecar(filepath.Join(dir, base+".json"))
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if meta.Title != "" {
+				job.title = meta.Title
+			}
+			job.description = meta.Description
+			if meta.Thumbnail != "" {
+				job.thumbnail = filepath.Join(dir, meta.Thumbnail)
+				if err := checkThumbnail(job.thumbnail); err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", filepath.Join(dir, base+".json"), err))
+					continue
+				}
+			}
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, errors.Join(errs...)
+}
+
+func readSidecar(path string) (sidecar, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return sidecar{}, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var meta sidecar
+	if err := decoder.Decode(&meta); err != nil {
+		return sidecar{}, fmt.Errorf("couldn't parse %s: %w", path, err)
+	}
+	return meta, nil
+}
+
+func checkThumbnail(path string) error {
+	if !slices.Contains(thumbnailExtensions, strings.ToLower(filepath.Ext(path))) {
+		return fmt.Errorf("thumbnail %s isn't a .jpg, .jpeg or .png file", path)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("couldn't find thumbnail: %w", err)
+	}
+	return nil
+}
+
+// titleFromFilename turns "my_first-clip" into "my first clip".
+func titleFromFilename(base string) string {
+	title := strings.Join(strings.FieldsFunc(base, func(r rune) bool {
+		return r == '_' || r == '-' || r == ' '
+	}), " ")
+	if title == "" {
+		return base
+	}
+	return title
+}
+
+// uploader uploads videos one step at a time, retrying each step on its own
+// so a failed file upload doesn't create a second draft.
+//
+// A draft whose video never uploads is moved to the trash, where it still
+// counts against the user's video quota until the server purges it.
+type uploader struct {
+	client  *client.Client
+	retries int
+	total   int
+
+	mu       sync.Mutex
+	progress map[string]int
+}
+
+func (u *uploader) upload(ctx context.Context, job uploadJob) uploadResult {
+	result := uploadResult{job: job}
+	var video client.Video
+	var mayExist bool
+	result.err = u.retry(ctx, job, &result, "create draft", func() error {
+		// Creating a video isn't idempotent: a request that failed without a
+		// response may still have created the draft, so look for it before
+		// creating another.
+		if mayExist {
+			draft, found, err := u.findDraft(ctx, job)
+			if err != nil {
+				return err
+			}
+			if found {
+				video = draft
+				return nil
+			}
+		}
+		var err error
+		video, err = u.client.CreateVideo(ctx, job.title, job.description)
+		mayExist = err != nil && !client.IsStatus(err, http.StatusTooManyRequests)
+		return err
+	})
+	if result.err != nil {
+		return result
+	}
+	result.videoID = video.ID
+
+	result.err = u.retry(ctx, job, &result, "upload video", func() error {
+		_, err := u.client.UploadVideoFile(ctx, video.ID, job.path, u.progressFunc(job))
+		return err
+	})
+	if result.err != nil {
+		// Don't leave a draft behind that running the upload again would
+		// duplicate.
+		cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+		defer cancel()
+		if err := u.client.DeleteVideo(cleanupCtx, video.ID); err == nil {
+			result.videoID = uuid.Nil
+		}
+		return result
+	}
+
+	if job.thumbnail != "" {
+		result.thumbnailErr = u.retry(ctx, job, &result, "upload thumbnail", func() error {
+			_, err := u.client.UploadThumbnailFile(ctx, video.ID, job.thumbnail, nil)
+			return err
+		})
+	}
+	u.logf(job, "done")
+	return result
+}
+
+// findDraft looks for a video with the job's title and description and no
+// video file yet, newest first.
+func (u *uploader) findDraft(ctx context.Context, job uploadJob) (client.Video, bool, error) {
+	videos, err := u.client.ListVideos(ctx)
+	if err != nil {
+		return client.Video{}, false, err
+	}
+	for _, video := range videos {
+		if video.Title == job.title && video.Description == job.description && video.VideoURL == nil {
+			return video, true, nil
+		}
+	}
+	return client.Video{}, false, nil
+}
+
+// retry runs step until it succeeds, fails in a way retrying won't fix, or
+// has been retried u.retries times. It waits longer between each attempt,
+// or as long as the server asks.
+func (u *uploader) retry(ctx context.Context, job uploadJob, result *uploadResult, name string, step func() error) error {
+	delay := retryBaseDelay
+	for attempt := 0; ; attempt++ {
+		result.attempts++
+		err := step()
+		if err == nil {
+			return nil
+		}
+		if attempt == u.retries || !retryable(ctx, err) {
+			return fmt.Errorf("couldn't %s: %w", name, err)
+		}
+
+		wait := delay
+		var apiErr *client.APIError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > wait {
+			wait = apiErr.RetryAfter
+		}
+		u.logf(job, "couldn't %s, retrying in %s: %v", name, wait, err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return fmt.Errorf("couldn't %s: %w", name, ctx.Err())
+		}
+		delay = min(delay*2, retryMaxDelay)
+	}
+}
+
+// retryable reports whether an error might go away by trying again: network
+// errors and server errors might, rejected requests won't.
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var apiErr *client.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// progressFunc logs an upload's progress every 25%.
+func (u *uploader) progressFunc(job uploadJob) client.ProgressFunc {
+	return func(sent, total int64) {
+		if total <= 0 {
+			return
+		}
+		percent := int(sent * 100 / total)
+		step := percent / 25 * 25
+		u.mu.Lock()
+		if u.progress == nil {
+			u.progress = make(map[string]int)
+		}
+		last, seen := u.progress[job.path]
+		if seen && step <= last {
+			u.mu.Unlock()
+			return
+		}
+		u.progress[job.path] = step
+		u.mu.Unlock()
+		u.logf(job, "uploading, %d%%", step)
+	}
+}
+
+func (u *uploader) logf(job uploadJob, format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "[%d/%d] %s: %s\n", job.n, u.total, filepath.Base(job.path), fmt.Sprintf(format, args...))
+}
+
+// printSummary prints a line per video and returns an error if any failed.
+func printSummary(results []uploadResult) error {
+	var uploaded, skipped, failed, thumbnailsFailed, notStarted int
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tVIDEO ID\tSTATUS")
+	for _, result := range results {
+		if result.job.path == "" {
+			notStarted++
+			continue
+		}
+		videoID := "-"
+		if result.videoID != uuid.Nil {
+			videoID = result.videoID.String()
+		}
+		var status string
+		switch {
+		case result.skipped:
+			skipped++
+			status = "skipped"
+		case result.err != nil:
+			failed++
+			status = "failed: " + result.err.Error()
+		case result.thumbnailErr != nil:
+			uploaded++
+			thumbnailsFailed++
+			status = "uploaded without thumbnail: " + result.thumbnailErr.Error()
+		default:
+			uploaded++
+			status = "uploaded"
+		}
+		if result.attempts > 1 {
+			status += fmt.Sprintf(" (%d attempts)", result.attempts)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", result.job.path, videoID, status)
+	}
+	tw.Flush()
+
+	fmt.Printf("\n%d uploaded, %d skipped, %d failed", uploaded, skipped, failed)
+	if thumbnailsFailed > 0 {
+		fmt.Printf(", %d without thumbnail", thumbnailsFailed)
+	}
+	if notStarted > 0 {
+		fmt.Printf(", %d not started", notStarted)
+	}
+	fmt.Println()
+
+	if failed > 0 || thumbnailsFailed > 0 || notStarted > 0 {
+		return errors.New("not every video was uploaded")
+	}
+	return nil
+}
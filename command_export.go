@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/config"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const exportPageSize = 500
+
+type exportedUser struct {
+	ID            uuid.UUID          `json:"id"`
+	Email         string             `json:"email"`
+	Role          database.Role      `json:"role"`
+	CreatedAt     time.Time          `json:"created_at"`
+	VerifiedAt    *time.Time         `json:"verified_at"`
+	DisabledAt    *time.Time         `json:"disabled_at"`
+	TOTPEnabledAt *time.Time         `json:"totp_enabled_at"`
+	Videos        []database.Video   `json:"videos"`
+	Playlists     []exportedPlaylist `json:"playlists"`
+}
+
+type exportedPlaylist struct {
+	database.Playlist
+	VideoIDs []uuid.UUID `json:"video_ids"`
+}
+
+// commandExport writes users with their videos, trashed ones included, and
+// playlists as a single JSON document. Password hashes and other secrets are
+// left out.
+func commandExport(ctx context.Context, cfg *apiConfig, conf config.Config, args []string) error {
+	flags := commandFlags("export")
+	email := flags.String("user", "", "only export the user with this email address")
+	output := flags.String("output", "-", "file to write to, or - for stdout")
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return errors.New("export takes no arguments")
+	}
+
+	db := cfg.db.WithContext(ctx)
+	var users []database.User
+	if *email != "" {
+		user, err := db.GetUserByEmail(*email)
+		if err != nil {
+			return err
+		}
+		if user.ID == uuid.Nil {
+			return fmt.Errorf("no user with email %s", *email)
+		}
+		users = append(users, user)
+	} else {
+		for {
+			page, total, err := db.GetUsers(database.GetUsersParams{Limit: exportPageSize, Offset: len(users)})
+			if err != nil {
+				return err
+			}
+			users = append(users, page...)
+			if len(page) == 0 || len(users) >= total {
+				break
+			}
+		}
+	}
+
+	export := struct {
+		ExportedAt time.Time      `json:"exported_at"`
+		Users      []exportedUser `json:"users"`
+	}{
+		ExportedAt: time.Now().UTC(),
+		Users:      make([]exportedUser, 0, len(users)),
+	}
+	for _, user := range users {
+		exported, err := cfg.exportUser(ctx, user)
+		if err != nil {
+			return fmt.Errorf("couldn't export user %s: %w", user.ID, err)
+		}
+		export.Users = append(export.Users, exported)
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		// Exports hold every user's email address.
+		f, err := os.OpenFile(*output, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(export); err != nil {
+		return err
+	}
+
+	cfg.logger.Info("Exported users", "count", len(export.Users), "output", *output)
+	return nil
+}
+
+func (cfg *apiConfig) exportUser(ctx context.Context, user database.User) (exportedUser, error) {
+	db := cfg.db.WithContext(ctx)
+	exported := exportedUser{
+		ID:            user.ID,
+		Email:         user.Email,
+		Role:          user.Role,
+		CreatedAt:     user.CreatedAt,
+		VerifiedAt:    user.VerifiedAt,
+		DisabledAt:    user.DisabledAt,
+		TOTPEnabledAt: user.TOTPEnabledAt,
+		Playlists:     []exportedPlaylist{},
+	}
+
+	videos, err := db.GetVideos(user.ID)
+	if err != nil {
+		return exportedUser{}, err
+	}
+	trashed, err := db.GetTrashedVideos(user.ID)
+	if err != nil {
+		return exportedUser{}, err
+	}
+	exported.Videos = append(videos, trashed...)
+
+	playlists, err := db.GetPlaylists(user.ID)
+	if err != nil {
+		return exportedUser{}, err
+	}
+	for _, playlist := range playlists {
+		playlistVideos, err := db.GetPlaylistVideos(playlist.ID)
+		if err != nil {
+			return exportedUser{}, err
+		}
+		videoIDs := make([]uuid.UUID, len(playlistVideos))
+		for i, video := range playlistVideos {
+			videoIDs[i] = video.ID
+		}
+		exported.Playlists = append(exported.Playlists, exportedPlaylist{Playlist: playlist, VideoIDs: videoIDs})
+	}
+	return exported, nil
+}
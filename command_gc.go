@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/config"
+)
+
+// orphanMinAge keeps gc away from thumbnails an upload has written but not
+// yet recorded against its video.
+const orphanMinAge = time.Hour
+
+// commandGC does the clean-up the server otherwise leaves to time: it purges
+// trash past its retention, records the sizes of assets uploaded before
+// they were tracked, deletes expired tokens and removes thumbnails no video
+// refers to.
+func commandGC(ctx context.Context, cfg *apiConfig, conf config.Config, args []string) error {
+	flags := commandFlags("gc")
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return errors.New("gc takes no arguments")
+	}
+
+	if err := cfg.purgeTrash(ctx); err != nil {
+		return fmt.Errorf("couldn't purge trash: %w", err)
+	}
+
+	backfilled, err := cfg.backfillAssetSizes(ctx)
+	if err != nil {
+		return fmt.Errorf("couldn't record asset sizes: %w", err)
+	}
+	cfg.logger.Info("Recorded sizes of assets uploaded before quotas", "count", backfilled)
+
+	deleted, err := cfg.db.WithContext(ctx).DeleteExpiredTokens(time.Now())
+	if err != nil {
+		return err
+	}
+	cfg.logger.Info("Deleted expired tokens", "count", deleted)
+
+	removed, err := cfg.removeOrphanedThumbnails(ctx)
+	if err != nil {
+		return fmt.Errorf("couldn't remove orphaned thumbnails: %w", err)
+	}
+	cfg.logger.Info("Removed orphaned thumbnails", "count", removed)
+	return nil
+}
+
+// removeOrphanedThumbnails deletes files in the assets directory that no
+// video, trashed or not, uses as its thumbnail.
+func (cfg *apiConfig) removeOrphanedThumbnails(ctx context.Context) (int, error) {
+	videos, err := cfg.db.WithContext(ctx).GetAllVideos()
+	if err != nil {
+		return 0, err
+	}
+	// Matched on the file name alone, so thumbnails recorded while the
+	// server ran on another port or host still count.
+	used := make(map[string]bool, len(videos))
+	for _, video := range videos {
+		if video.ThumbnailURL == nil {
+			continue
+		}
+		if u, err := url.Parse(*video.ThumbnailURL); err == nil {
+			used[path.Base(u.Path)] = true
+		}
+	}
+
+	entries, err := os.ReadDir(cfg.assetsRoot)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || used[entry.Name()] {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return removed, err
+		}
+		if time.Since(info.ModTime()) < orphanMinAge {
+			continue
+		}
+		if err := os.Remove(cfg.getAssetDiskPath(entry.Name())); err != nil {
+			return removed, err
+		}
+		cfg.logger.Info("Removed orphaned thumbnail", "file", entry.Name())
+		removed++
+	}
+	return removed, nil
+}
+
+// backfillAssetSizes records the size of every thumbnail and video file
+// stored before sizes were tracked, which would otherwise count as free
+// against storage quotas. Thumbnails are measured on disk and video files
+// in S3. Assets that have gone missing are left at zero.
+func (cfg *apiConfig) backfillAssetSizes(ctx context.Context) (int, error) {
+	videos, err := cfg.db.WithContext(ctx).GetAllVideos()
+	if err != nil {
+		return 0, err
+	}
+
+	backfilled := 0
+	for _, video := range videos {
+		if ctx.Err() != nil {
+			return backfilled, ctx.Err()
+		}
+
+		if video.ThumbnailURL != nil && video.ThumbnailSize == 0 {
+			// Matched on the file name alone, as in removeOrphanedThumbnails.
+			u, err := url.Parse(*video.ThumbnailURL)
+			if err == nil && strings.HasPrefix(u.Path, "/assets/") {
+				info, err := os.Stat(cfg.getAssetDiskPath(path.Base(u.Path)))
+				switch {
+				case err == nil:
+					err = cfg.db.WithContext(ctx).SetThumbnailSize(video.ID, *video.ThumbnailURL, info.Size())
+					if err != nil {
+						return backfilled, err
+					}
+					backfilled++
+				case !errors.Is(err, os.ErrNotExist):
+					return backfilled, err
+				}
+			}
+		}
+
+		if video.VideoURL != nil && video.VideoSize == 0 {
+			key, ok := cfg.videoAssetKey(*video.VideoURL)
+			if !ok {
+				continue
+			}
+			start := time.Now()
+			object, err := cfg.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+				Bucket: aws.String(cfg.s3Bucket),
+				Key:    aws.String(key),
+			})
+			observeS3Request("HeadObject", start, err)
+			var notFound *types.NotFound
+			if errors.As(err, &notFound) {
+				cfg.logger.Warn("Video file is missing from S3", "video_id", video.ID, "key", key)
+				continue
+			}
+			if err != nil {
+				return backfilled, fmt.Errorf("couldn't look up '%s': %w", key, err)
+			}
+			err = cfg.db.WithContext(ctx).SetVideoSize(video.ID, *video.VideoURL, aws.ToInt64(object.ContentLength))
+			if err != nil {
+				return backfilled, err
+			}
+			backfilled++
+		}
+	}
+	return backfilled, nil
+}
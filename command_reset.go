@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/config"
+)
+
+// commandReset empties the database, for starting over in development. It
+// leaves stored thumbnails and videos alone.
+func commandReset(ctx context.Context, cfg *apiConfig, conf config.Config, args []string) error {
+	flags := commandFlags("reset")
+	yes := flags.Bool("yes", false, "confirm that everything in the database should be deleted")
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return errors.New("reset takes no arguments")
+	}
+
+	if cfg.platform != "dev" {
+		return errors.New("reset is only allowed when PLATFORM is dev")
+	}
+	if !*yes {
+		return errors.New("reset deletes every user, video and playlist, pass --yes to confirm")
+	}
+
+	err := cfg.db.WithContext(ctx).Reset()
+	if err != nil {
+		return err
+	}
+	cfg.logger.Info("Database reset to initial state")
+	return nil
+}
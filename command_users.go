@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/config"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// commandCreateUser creates a user without going through sign-up. Unless the
+// password is piped in, a random one is generated and printed once.
+func commandCreateUser(ctx context.Context, cfg *apiConfig, conf config.Config, args []string) error {
+	flags := commandFlags("create-user")
+	role := flags.String("role", string(database.RoleUser), "role to give the user: user, moderator or admin")
+	verified := flags.Bool("verified", false, "mark the email address as verified")
+	passwordStdin := flags.Bool("password-stdin", false, "read the password from the first line of stdin")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("create-user takes one email address")
+	}
+	email := flags.Arg(0)
+
+	if err := validateEmail(email); err != nil {
+		return fmt.Errorf("invalid email address: %w", err)
+	}
+	if !database.Role(*role).IsValid() {
+		return fmt.Errorf("unknown role %q", *role)
+	}
+
+	db := cfg.db.WithContext(ctx)
+	existing, err := db.GetUserByEmail(email)
+	if err != nil {
+		return err
+	}
+	if existing.ID != uuid.Nil {
+		return fmt.Errorf("a user with email %s already exists", email)
+	}
+
+	var password string
+	if *passwordStdin {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("couldn't read password from stdin: %w", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+		if password == "" {
+			return errors.New("password is empty")
+		}
+	} else {
+		password, err = auth.MakeRefreshToken()
+		if err != nil {
+			return err
+		}
+	}
+	hashedPassword, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	user, err := db.CreateUser(database.CreateUserParams{
+		Email:    email,
+		Password: hashedPassword,
+	})
+	if err != nil {
+		return err
+	}
+	cfg.auditCommand(ctx, "create-user", auditEvent(auditUserCreate, database.AuditOutcomeSuccess, uuid.Nil, auditTargetUser, user.ID))
+
+	if database.Role(*role) != database.RoleUser {
+		if err := db.SetUserRole(user.ID, database.Role(*role)); err != nil {
+			return err
+		}
+		event := auditEvent(auditAdminRole, database.AuditOutcomeSuccess, uuid.Nil, auditTargetUser, user.ID)
+		event.Detail = fmt.Sprintf("%s -> %s", database.RoleUser, *role)
+		cfg.auditCommand(ctx, "create-user", event)
+	}
+	if *verified {
+		if err := db.MarkEmailVerified(user.ID); err != nil {
+			return err
+		}
+	}
+
+	cfg.logger.Info("Created user", "user_id", user.ID, "email", email, "role", *role)
+	if !*passwordStdin {
+		fmt.Printf("Password for %s: %s\n", email, password)
+	}
+	return nil
+}
+
+// commandPromoteAdmin makes an existing user an admin. Anyone who can run it
+// already has the database, so unlike the admin API it needs no other admin.
+func commandPromoteAdmin(ctx context.Context, cfg *apiConfig, conf config.Config, args []string) error {
+	flags := commandFlags("promote-admin")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("promote-admin takes one email address")
+	}
+	email := flags.Arg(0)
+
+	db := cfg.db.WithContext(ctx)
+	user, err := db.GetUserByEmail(email)
+	if err != nil {
+		return err
+	}
+	if user.ID == uuid.Nil {
+		return fmt.Errorf("no user with email %s, sign up first", email)
+	}
+	if user.Role == database.RoleAdmin {
+		cfg.logger.Info("User is already an admin", "email", email)
+		return nil
+	}
+
+	err = db.SetUserRole(user.ID, database.RoleAdmin)
+	if err != nil {
+		return err
+	}
+	event := auditEvent(auditAdminRole, database.AuditOutcomeSuccess, uuid.Nil, auditTargetUser, user.ID)
+	event.Detail = fmt.Sprintf("%s -> %s", user.Role, database.RoleAdmin)
+	cfg.auditCommand(ctx, "promote-admin", event)
+
+	cfg.logger.Info("Promoted user to admin", "email", email)
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func videoETag(video database.Video) string {
+	return fmt.Sprintf(`"%s-%d"`, video.ID, video.Version)
+}
+
+// checkIfMatch reports whether the request's If-Match precondition holds for
+// the current state of video. A request without If-Match always passes.
+func checkIfMatch(r *http.Request, video database.Video) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+	etag := videoETag(video)
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		// If-Match uses strong comparison, so weak tags never match.
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfNoneMatch reports whether the request's If-None-Match header names
+// the current state of video, meaning the client's copy is up to date.
+func checkIfNoneMatch(r *http.Request, video database.Video) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+	etag := videoETag(video)
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		// If-None-Match uses weak comparison.
+		candidate = strings.TrimPrefix(candidate, "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// respondWithVideoConflict reports a failed UpdateVideo compare-and-swap.
+// Clients that sent If-Match asked for exactly this check, so they get 412;
+// everyone else gets 409.
+func respondWithVideoConflict(w http.ResponseWriter, r *http.Request, err error) {
+	if r.Header.Get("If-Match") != "" {
+		respondWithError(w, http.StatusPreconditionFailed, "Video has been modified", err)
+		return
+	}
+	respondWithError(w, http.StatusConflict, "Video was modified by another request, try again", err)
+}
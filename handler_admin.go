@@ -0,0 +1,399 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	adminUsersDefaultLimit = 50
+	adminUsersMaxLimit     = 200
+	impersonationLifetime  = time.Hour
+)
+
+// adminUser is what admins see of other users. It leaves out the password
+// hash and login secrets.
+type adminUser struct {
+	ID            uuid.UUID     `json:"id"`
+	CreatedAt     time.Time     `json:"created_at"`
+	Email         string        `json:"email"`
+	Role          database.Role `json:"role"`
+	VerifiedAt    *time.Time    `json:"verified_at"`
+	TOTPEnabledAt *time.Time    `json:"totp_enabled_at"`
+	DisabledAt    *time.Time    `json:"disabled_at"`
+}
+
+func newAdminUser(user database.User) adminUser {
+	return adminUser{
+		ID:            user.ID,
+		CreatedAt:     user.CreatedAt,
+		Email:         user.Email,
+		Role:          user.Role,
+		VerifiedAt:    user.VerifiedAt,
+		TOTPEnabledAt: user.TOTPEnabledAt,
+		DisabledAt:    user.DisabledAt,
+	}
+}
+
+func (cfg *apiConfig) handlerAdminUsersRetrieve(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Users  []adminUser `json:"users"`
+		Total  int         `json:"total"`
+		Limit  int         `json:"limit"`
+		Offset int         `json:"offset"`
+	}
+
+	if _, ok := cfg.requireRole(w, r, database.RoleAdmin); !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	limit, err := parseQueryInt(query.Get("limit"), adminUsersDefaultLimit)
+	if err != nil || limit < 1 || limit > adminUsersMaxLimit {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Limit must be between 1 and %d", adminUsersMaxLimit), err)
+		return
+	}
+	offset, err := parseQueryInt(query.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		respondWithError(w, http.StatusBadRequest, "Offset must be a non-negative integer", err)
+		return
+	}
+
+	users, total, err := cfg.db.WithContext(r.Context()).GetUsers(database.GetUsersParams{
+		Search: query.Get("q"),
+		Limit:  limit,
+		Offset: offset,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve users", err)
+		return
+	}
+
+	resp := response{
+		Users:  make([]adminUser, 0, len(users)),
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	}
+	for _, user := range users {
+		resp.Users = append(resp.Users, newAdminUser(user))
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (cfg *apiConfig) handlerAdminUserGet(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		adminUser
+		Usage database.UserUsage `json:"usage"`
+		Quota database.Quota     `json:"quota"`
+	}
+
+	if _, ok := cfg.requireRole(w, r, database.RoleAdmin); !ok {
+		return
+	}
+
+	userIDString := r.PathValue("userID")
+	userID, err := uuid.Parse(userIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	user, err := cfg.db.WithContext(r.Context()).GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	usage, err := cfg.db.WithContext(r.Context()).GetUserUsage(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get usage", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		adminUser: newAdminUser(*user),
+		Usage:     usage,
+		Quota:     cfg.quotaFor(*user),
+	})
+}
+
+func (cfg *apiConfig) handlerAdminUserRoleUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Role database.Role `json:"role"`
+	}
+
+	admin, ok := cfg.requireRole(w, r, database.RoleAdmin)
+	if !ok {
+		return
+	}
+	user, ok := cfg.getTargetUser(w, r, admin)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !params.Role.IsValid() {
+		respondWithError(w, http.StatusBadRequest, "Role must be user, moderator or admin", nil)
+		return
+	}
+
+	err = cfg.db.WithContext(r.Context()).SetUserRole(user.ID, params.Role)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update role", err)
+		return
+	}
+	event := auditEvent(auditAdminRole, database.AuditOutcomeSuccess, admin.ID, auditTargetUser, user.ID)
+	event.Detail = fmt.Sprintf("%s -> %s", user.Role, params.Role)
+	cfg.audit(r, event)
+
+	user.Role = params.Role
+	respondWithJSON(w, http.StatusOK, newAdminUser(user))
+}
+
+// handlerAdminUserQuotaUpdate overrides a user's quota. Leaving a limit out,
+// or setting it to null, goes back to the default for the user's role.
+func (cfg *apiConfig) handlerAdminUserQuotaUpdate(w http.ResponseWriter, r *http.Request) {
+	admin, ok := cfg.requireRole(w, r, database.RoleAdmin)
+	if !ok {
+		return
+	}
+	user, ok := cfg.getTargetUser(w, r, admin)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := database.Quota{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if (params.StorageBytes != nil && *params.StorageBytes < 0) || (params.VideoCount != nil && *params.VideoCount < 0) {
+		respondWithError(w, http.StatusBadRequest, "Quota limits can't be negative", nil)
+		return
+	}
+
+	err = cfg.db.WithContext(r.Context()).SetUserQuota(user.ID, params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update quota", err)
+		return
+	}
+	event := auditEvent(auditAdminQuota, database.AuditOutcomeSuccess, admin.ID, auditTargetUser, user.ID)
+	detail, _ := json.Marshal(params)
+	event.Detail = string(detail)
+	cfg.audit(r, event)
+
+	user.Quota = params
+	respondWithJSON(w, http.StatusOK, cfg.quotaFor(user))
+}
+
+func (cfg *apiConfig) handlerAdminUserDisable(w http.ResponseWriter, r *http.Request) {
+	admin, ok := cfg.requireRole(w, r, database.RoleAdmin)
+	if !ok {
+		return
+	}
+	user, ok := cfg.getTargetUser(w, r, admin)
+	if !ok {
+		return
+	}
+
+	err := cfg.db.WithContext(r.Context()).DisableUser(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't disable user", err)
+		return
+	}
+	cfg.audit(r, auditEvent(auditAdminDisable, database.AuditOutcomeSuccess, admin.ID, auditTargetUser, user.ID))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerAdminUserEnable(w http.ResponseWriter, r *http.Request) {
+	admin, ok := cfg.requireRole(w, r, database.RoleAdmin)
+	if !ok {
+		return
+	}
+	user, ok := cfg.getTargetUser(w, r, admin)
+	if !ok {
+		return
+	}
+
+	err := cfg.db.WithContext(r.Context()).EnableUser(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable user", err)
+		return
+	}
+	cfg.audit(r, auditEvent(auditAdminEnable, database.AuditOutcomeSuccess, admin.ID, auditTargetUser, user.ID))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerAdminUserPasswordReset forces a user to pick a new password. Their
+// current password stops working, every session is signed out, and they're
+// emailed a reset token.
+func (cfg *apiConfig) handlerAdminUserPasswordReset(w http.ResponseWriter, r *http.Request) {
+	admin, ok := cfg.requireRole(w, r, database.RoleAdmin)
+	if !ok {
+		return
+	}
+	user, ok := cfg.getTargetUser(w, r, admin)
+	if !ok {
+		return
+	}
+
+	randomPassword, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create password", err)
+		return
+	}
+	hashedPassword, err := auth.HashPassword(randomPassword)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+		return
+	}
+
+	err = cfg.db.WithContext(r.Context()).ReplaceUserPassword(user.ID, hashedPassword)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password", err)
+		return
+	}
+	cfg.audit(r, auditEvent(auditAdminReset, database.AuditOutcomeSuccess, admin.ID, auditTargetUser, user.ID))
+
+	err = cfg.sendPasswordResetEmail(r.Context(), user.Email)
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Password was reset but the reset email couldn't be sent", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handlerAdminUserImpersonate gives an admin a short-lived access token for
+// another user, to see what they see when helping them. There's no refresh
+// token, and the token records which admin it was issued to.
+func (cfg *apiConfig) handlerAdminUserImpersonate(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	admin, ok := cfg.requireRole(w, r, database.RoleAdmin)
+	if !ok {
+		return
+	}
+	user, ok := cfg.getTargetUser(w, r, admin)
+	if !ok {
+		return
+	}
+	if user.Role.Includes(database.RoleAdmin) {
+		cfg.audit(r, auditEvent(auditAdminImpersonate, database.AuditOutcomeDenied, admin.ID, auditTargetUser, user.ID))
+		respondWithError(w, http.StatusForbidden, "Admins can't be impersonated", nil)
+		return
+	}
+	if user.DisabledAt != nil {
+		respondWithError(w, http.StatusConflict, "Disabled users can't be impersonated", nil)
+		return
+	}
+
+	expiresAt := time.Now().UTC().Add(impersonationLifetime)
+	token, err := auth.MakeImpersonationJWT(user.ID, admin.ID, cfg.jwtKeys, impersonationLifetime)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create token", err)
+		return
+	}
+	cfg.audit(r, auditEvent(auditAdminImpersonate, database.AuditOutcomeSuccess, admin.ID, auditTargetUser, user.ID))
+
+	respondWithJSON(w, http.StatusOK, response{
+		Token:     token,
+		ExpiresAt: expiresAt,
+	})
+}
+
+// handlerAdminVideoGet lets moderators look at any video, including ones
+// that are in their owner's trash.
+func (cfg *apiConfig) handlerAdminVideoGet(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	if _, ok := cfg.requireRole(w, r, database.RoleModerator); !ok {
+		return
+	}
+
+	video, err := cfg.db.WithContext(r.Context()).GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		video, err = cfg.db.WithContext(r.Context()).GetTrashedVideo(videoID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+			return
+		}
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// getTargetUser loads the user named by the userID path value. Admins can't
+// target themselves, so they can't lock themselves out or leave the site
+// without an admin by accident.
+func (cfg *apiConfig) getTargetUser(w http.ResponseWriter, r *http.Request, admin database.User) (database.User, bool) {
+	userIDString := r.PathValue("userID")
+	userID, err := uuid.Parse(userIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return database.User{}, false
+	}
+	if userID == admin.ID {
+		respondWithError(w, http.StatusConflict, "You can't change your own account", nil)
+		return database.User{}, false
+	}
+
+	user, err := cfg.db.WithContext(r.Context()).GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return database.User{}, false
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return database.User{}, false
+	}
+
+	return *user, true
+}
+
+// parseQueryInt parses an integer query parameter, returning fallback if it
+// wasn't given.
+func parseQueryInt(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(value)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	auditEventsDefaultLimit = 100
+	auditEventsMaxLimit     = 1000
+)
+
+// handlerAdminAuditEventsRetrieve returns a page of audit events, newest
+// first. Pass next_before back as before to get the page after it.
+func (cfg *apiConfig) handlerAdminAuditEventsRetrieve(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Events     []database.AuditEvent `json:"events"`
+		NextBefore *int64                `json:"next_before"`
+	}
+
+	if _, ok := cfg.requireRole(w, r, database.RoleAdmin); !ok {
+		return
+	}
+
+	filter, ok := parseAuditEventFilter(w, r)
+	if !ok {
+		return
+	}
+	limit, err := parseQueryInt(r.URL.Query().Get("limit"), auditEventsDefaultLimit)
+	if err != nil || limit < 1 || limit > auditEventsMaxLimit {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Limit must be between 1 and %d", auditEventsMaxLimit), err)
+		return
+	}
+	filter.Limit = limit
+
+	events, err := cfg.db.WithContext(r.Context()).GetAuditEvents(filter)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve audit events", err)
+		return
+	}
+
+	resp := response{Events: events}
+	if len(events) == limit {
+		resp.NextBefore = &events[len(events)-1].ID
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// handlerAdminAuditEventsExport streams every matching audit event as JSON
+// Lines, newest first.
+func (cfg *apiConfig) handlerAdminAuditEventsExport(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.requireRole(w, r, database.RoleAdmin); !ok {
+		return
+	}
+
+	filter, ok := parseAuditEventFilter(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit_events.jsonl"`)
+	w.WriteHeader(http.StatusOK)
+
+	// Once the first line is out the status can't change, so a failure
+	// part way through can only cut the export short.
+	encoder := json.NewEncoder(w)
+	err := cfg.db.WithContext(r.Context()).EachAuditEvent(filter, func(event database.AuditEvent) error {
+		return encoder.Encode(event)
+	})
+	if err != nil {
+		requestLogger(r).Error("Couldn't export audit events", "error", err)
+	}
+}
+
+// parseAuditEventFilter reads the filters shared by the audit event
+// endpoints from the query string. On failure it writes the error response
+// and returns false.
+func parseAuditEventFilter(w http.ResponseWriter, r *http.Request) (database.AuditEventFilter, bool) {
+	query := r.URL.Query()
+	filter := database.AuditEventFilter{
+		Action:   query.Get("action"),
+		Outcome:  database.AuditOutcome(query.Get("outcome")),
+		TargetID: query.Get("target_id"),
+	}
+
+	if value := query.Get("actor_id"); value != "" {
+		actorID, err := uuid.Parse(value)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid actor ID", err)
+			return database.AuditEventFilter{}, false
+		}
+		filter.ActorID = actorID
+	}
+
+	for _, bound := range []struct {
+		name string
+		dst  *time.Time
+	}{
+		{"since", &filter.Since},
+		{"until", &filter.Until},
+	} {
+		value := query.Get(bound.name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid %s time, expected RFC 3339", bound.name), err)
+			return database.AuditEventFilter{}, false
+		}
+		*bound.dst = t
+	}
+
+	if value := query.Get("before"); value != "" {
+		beforeID, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || beforeID < 1 {
+			respondWithError(w, http.StatusBadRequest, "Before must be a positive event ID", err)
+			return database.AuditEventFilter{}, false
+		}
+		filter.BeforeID = beforeID
+	}
+
+	return filter, true
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// readinessTimeout bounds how long /readyz waits for its checks, so a hung
+// dependency makes the probe fail rather than time out.
+const readinessTimeout = 5 * time.Second
+
+// readinessCacheFor is how long a readiness report is reused. /readyz needs
+// no credentials, so without it anyone could make the server run ffmpeg and
+// call S3 as often as they liked.
+const readinessCacheFor = 5 * time.Second
+
+// readinessCheck is one check's part of a readiness report. Error is only
+// filled in when READINESS_ERRORS is set; failures are always logged.
+type readinessCheck struct {
+	Status   string `json:"status"`
+	Version  string `json:"version,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// readinessCache holds the latest readiness report. Requests that arrive
+// while the checks run wait for them rather than running their own.
+type readinessCache struct {
+	mu        sync.Mutex
+	checkedAt time.Time
+	ready     bool
+	checks    map[string]readinessCheck
+}
+
+func newReadinessCache() *readinessCache {
+	return &readinessCache{}
+}
+
+// get returns the cached report, running check for a new one if it's stale.
+func (c *readinessCache) get(check func() (bool, map[string]readinessCheck)) (bool, map[string]readinessCheck) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.checks == nil || time.Since(c.checkedAt) >= readinessCacheFor {
+		c.ready, c.checks = check()
+		c.checkedAt = time.Now()
+	}
+	return c.ready, c.checks
+}
+
+// handlerHealthz reports that the process is up and serving requests. It
+// doesn't look at any dependencies; that's what /readyz is for.
+func (cfg *apiConfig) handlerHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, struct {
+		Status string `json:"status"`
+	}{
+		Status: "ok",
+	})
+}
+
+// handlerReadyz checks everything Tubely needs to serve uploads and reports
+// each check's outcome, answering 503 if any of them failed. Anyone can call
+// it, so why a check failed is only included if READINESS_ERRORS is set.
+func (cfg *apiConfig) handlerReadyz(w http.ResponseWriter, r *http.Request) {
+	ready, checks := cfg.readiness.get(func() (bool, map[string]readinessCheck) {
+		return cfg.checkReadiness(r)
+	})
+
+	status, code := "ok", http.StatusOK
+	if !ready {
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, code, struct {
+		Status string                    `json:"status"`
+		Checks map[string]readinessCheck `json:"checks"`
+	}{
+		Status: status,
+		Checks: checks,
+	})
+}
+
+// checkReadiness runs every readiness check at once, returning whether they
+// all passed and the outcome of each.
+func (cfg *apiConfig) checkReadiness(r *http.Request) (bool, map[string]readinessCheck) {
+	// The report is shared with other requests, so it mustn't fail because
+	// this one was cancelled.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), readinessTimeout)
+	defer cancel()
+	logger := requestLogger(r)
+
+	checks := map[string]func(context.Context) (string, error){
+		"database": cfg.checkDatabase,
+		"assets":   cfg.checkAssetsDir,
+		"ffmpeg":   func(ctx context.Context) (string, error) { return checkCommandVersion(ctx, "ffmpeg") },
+		"ffprobe":  func(ctx context.Context) (string, error) { return checkCommandVersion(ctx, "ffprobe") },
+		"s3":       cfg.checkS3Bucket,
+	}
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results = make(map[string]readinessCheck, len(checks))
+		ready   = true
+	)
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			version, err := check(ctx)
+			duration := time.Since(start)
+			result := readinessCheck{
+				Status:   "ok",
+				Version:  version,
+				Duration: duration.Round(time.Microsecond).String(),
+			}
+			if err != nil {
+				result.Status = "fail"
+				if cfg.readinessErrors {
+					result.Error = err.Error()
+				}
+				logger.Warn("Readiness check failed", "check", name, "duration", duration, "error", err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			results[name] = result
+			if err != nil {
+				ready = false
+			}
+		}()
+	}
+	wg.Wait()
+	return ready, results
+}
+
+func (cfg *apiConfig) checkDatabase(ctx context.Context) (string, error) {
+	return "", cfg.db.WithContext(ctx).Ping()
+}
+
+// checkAssetsDir makes sure thumbnails can still be written to the assets
+// directory.
+func (cfg *apiConfig) checkAssetsDir(ctx context.Context) (string, error) {
+	f, err := os.CreateTemp(cfg.assetsRoot, ".readyz-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString("ok"); err != nil {
+		f.Close()
+		return "", err
+	}
+	return "", f.Close()
+}
+
+// checkCommandVersion looks for name on PATH and returns the version it
+// reports, as in "ffmpeg version 6.1.1 Copyright ...".
+func checkCommandVersion(ctx context.Context, name string) (string, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return "", err
+	}
+	out, err := exec.CommandContext(ctx, path, "-version").Output()
+	if err != nil {
+		return "", err
+	}
+	firstLine, _, _ := strings.Cut(string(out), "\n")
+	fields := strings.Fields(firstLine)
+	if len(fields) < 3 || fields[1] != "version" {
+		return "", errors.New("unrecognised -version output: " + firstLine)
+	}
+	return fields[2], nil
+}
+
+func (cfg *apiConfig) checkS3Bucket(ctx context.Context) (string, error) {
+	start := time.Now()
+	_, err := cfg.s3Client.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(cfg.s3Bucket),
+	})
+	observeS3Request("HeadBucket", start, err)
+	return "", err
+}
@@ -0,0 +1,12 @@
+package main
+
+import (
+	"net/http"
+)
+
+func (cfg *apiConfig) handlerJWKS(w http.ResponseWriter, r *http.Request) {
+	// Verifiers may cache the keys, but only briefly, so they pick up a newly
+	// added key soon after a rotation.
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, http.StatusOK, cfg.jwtKeys.JWKS())
+}
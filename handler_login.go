@@ -2,11 +2,14 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"time"
 
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/api"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
 )
 
 func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
@@ -14,61 +17,162 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		Password string `json:"password"`
 		Email    string `json:"email"`
 	}
-	type response struct {
-		database.User
-		Token        string `json:"token"`
-		RefreshToken string `json:"refresh_token"`
+
+	ip := clientIP(r)
+	if retryAfter := cfg.loginThrottle.blockedFor(ip); retryAfter > 0 {
+		cfg.audit(r, auditLogin(database.AuditOutcomeFailure, uuid.Nil, "too many failed logins from this IP"))
+		respondWithRetryAfter(w, retryAfter, "Too many failed logins, try again later")
+		return
 	}
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
 	err := decoder.Decode(&params)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
 		return
 	}
 
-	user, err := cfg.db.GetUserByEmail(params.Email)
+	user, err := cfg.db.WithContext(r.Context()).GetUserByEmail(params.Email)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user.ID == uuid.Nil {
+		auth.CheckPasswordHashDummy(params.Password)
+		cfg.loginThrottle.recordFailure(ip)
+		cfg.audit(r, auditLogin(database.AuditOutcomeFailure, uuid.Nil, "unknown email"))
+		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", nil)
+		return
+	}
+
+	// A locked account only says so, with how long to wait, once the right
+	// password is given. A wrong one gets the usual answer, so locking can't
+	// be used to find out which emails have accounts.
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		if err := auth.CheckPasswordHash(params.Password, user.Password); err != nil {
+			cfg.loginThrottle.recordFailure(ip)
+			cfg.audit(r, auditLogin(database.AuditOutcomeFailure, user.ID, "account locked"))
+			respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
+			return
+		}
+		cfg.audit(r, auditLogin(database.AuditOutcomeFailure, user.ID, "account locked"))
+		respondWithRetryAfter(w, time.Until(*user.LockedUntil), "Account temporarily locked after too many failed logins")
 		return
 	}
 
 	err = auth.CheckPasswordHash(params.Password, user.Password)
 	if err != nil {
+		cfg.loginThrottle.recordFailure(ip)
+		cfg.recordFailedLogin(r, user)
+		cfg.audit(r, auditLogin(database.AuditOutcomeFailure, user.ID, "wrong password"))
 		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
 		return
 	}
 
-	accessToken, err := auth.MakeJWT(
-		user.ID,
-		cfg.jwtSecret,
+	if user.DisabledAt != nil {
+		cfg.audit(r, auditLogin(database.AuditOutcomeDenied, user.ID, "account disabled"))
+		respondWithError(w, http.StatusForbidden, "Account disabled", nil)
+		return
+	}
+
+	if user.TOTPEnabledAt != nil {
+		cfg.respondWithMFAChallenge(w, user)
+		return
+	}
+
+	cfg.completeLogin(w, r, user)
+}
+
+// completeLogin clears the failed login counters and responds with a new
+// access and refresh token for the user.
+func (cfg *apiConfig) completeLogin(w http.ResponseWriter, r *http.Request, user database.User) {
+	type response struct {
+		api.User
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	cfg.loginThrottle.recordSuccess(clientIP(r))
+	if user.FailedLoginCount > 0 {
+		err := cfg.db.WithContext(r.Context()).ResetFailedLogins(user.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't reset failed logins", err)
+			return
+		}
+	}
+
+	accessToken, refreshToken, err := cfg.createSession(r, user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create session", err)
+		return
+	}
+	cfg.audit(r, auditLogin(database.AuditOutcomeSuccess, user.ID, ""))
+
+	respondWithJSON(w, http.StatusOK, response{
+		User:         user.Public(),
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+	})
+}
+
+// createSession issues a new access token and a new stored refresh token for
+// the user.
+func (cfg *apiConfig) createSession(r *http.Request, userID uuid.UUID) (accessToken, refreshToken string, err error) {
+	accessToken, err = auth.MakeLoginJWT(
+		userID,
+		cfg.jwtKeys,
 		time.Hour*24*30,
 	)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT", err)
-		return
+		return "", "", fmt.Errorf("couldn't create access JWT: %w", err)
 	}
 
+	refreshToken, err = cfg.createRefreshToken(r, userID)
+	if err != nil {
+		return "", "", err
+	}
+
+	return accessToken, refreshToken, nil
+}
+
+// createRefreshToken stores a new refresh token for the user, noting the
+// client it was issued to so it can be recognised in the session list.
+func (cfg *apiConfig) createRefreshToken(r *http.Request, userID uuid.UUID) (string, error) {
 	refreshToken, err := auth.MakeRefreshToken()
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token", err)
-		return
+		return "", fmt.Errorf("couldn't create refresh token: %w", err)
 	}
 
-	_, err = cfg.db.CreateRefreshToken(database.CreateRefreshTokenParams{
-		UserID:    user.ID,
+	_, err = cfg.db.WithContext(r.Context()).CreateRefreshToken(database.CreateRefreshTokenParams{
+		UserID:    userID,
 		Token:     refreshToken,
 		ExpiresAt: time.Now().UTC().Add(time.Hour * 24 * 60),
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
 	})
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't save refresh token", err)
+		return "", fmt.Errorf("couldn't save refresh token: %w", err)
+	}
+
+	return refreshToken, nil
+}
+
+// recordFailedLogin counts a wrong password against the account and locks
+// it once it has run out of free attempts.
+func (cfg *apiConfig) recordFailedLogin(r *http.Request, user database.User) {
+	failures, err := cfg.db.WithContext(r.Context()).RecordFailedLogin(user.ID)
+	if err != nil {
+		requestLogger(r).Error("Couldn't record failed login", "user_id", user.ID, "error", err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, response{
-		User:         user,
-		Token:        accessToken,
-		RefreshToken: refreshToken,
-	})
+	lockout := backoff(failures, accountLoginFreeFailures, accountLoginBaseLockout, accountLoginMaxLockout)
+	if lockout == 0 {
+		return
+	}
+	err = cfg.db.WithContext(r.Context()).LockUser(user.ID, time.Now().Add(lockout))
+	if err != nil {
+		requestLogger(r).Error("Couldn't lock user", "user_id", user.ID, "error", err)
+	}
 }
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcStateCookie   = "tubely_oidc_state"
+	oidcLoginLifetime = 10 * time.Minute
+)
+
+// oidcClient holds what's needed to run the authorization code flow against
+// a single OpenID Connect provider.
+type oidcClient struct {
+	oauth2   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+	logins   *oidcLoginStore
+}
+
+func newOIDCClient(ctx context.Context, issuerURL, clientID, clientSecret, redirectURL string) (*oidcClient, error) {
+	provider, err := oidc.NewProvider(ctx, issuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("error discovering OIDC provider '%s': %w", issuerURL, err)
+	}
+
+	return &oidcClient{
+		oauth2: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       []string{oidc.ScopeOpenID, "email"},
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: clientID}),
+		logins:   newOIDCLoginStore(),
+	}, nil
+}
+
+// oidcLogin is the per-attempt secret state, kept server side and keyed by
+// the OAuth state parameter.
+type oidcLogin struct {
+	nonce        string
+	codeVerifier string
+	expiresAt    time.Time
+}
+
+type oidcLoginStore struct {
+	mu     sync.Mutex
+	logins map[string]oidcLogin
+}
+
+func newOIDCLoginStore() *oidcLoginStore {
+	return &oidcLoginStore{
+		logins: map[string]oidcLogin{},
+	}
+}
+
+func (s *oidcLoginStore) put(state string, login oidcLogin) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, existing := range s.logins {
+		if now.After(existing.expiresAt) {
+			delete(s.logins, key)
+		}
+	}
+	s.logins[state] = login
+}
+
+// take removes and returns the login for state. Each state can only be used
+// once.
+func (s *oidcLoginStore) take(state string) (oidcLogin, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	login, ok := s.logins[state]
+	if !ok {
+		return oidcLogin{}, false
+	}
+	delete(s.logins, state)
+	if time.Now().After(login.expiresAt) {
+		return oidcLogin{}, false
+	}
+	return login, true
+}
+
+func (cfg *apiConfig) handlerOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if cfg.oidc == nil {
+		respondWithError(w, http.StatusNotFound, "SSO login is not configured", nil)
+		return
+	}
+
+	state, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create state", err)
+		return
+	}
+	nonce, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create nonce", err)
+		return
+	}
+	codeVerifier := oauth2.GenerateVerifier()
+
+	cfg.oidc.logins.put(state, oidcLogin{
+		nonce:        nonce,
+		codeVerifier: codeVerifier,
+		expiresAt:    time.Now().Add(oidcLoginLifetime),
+	})
+
+	// Binding the state to this browser stops an attacker from completing
+	// a login they started in someone else's browser.
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/oidc",
+		MaxAge:   int(oidcLoginLifetime.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	authURL := cfg.oidc.oauth2.AuthCodeURL(
+		state,
+		oauth2.S256ChallengeOption(codeVerifier),
+		oidc.Nonce(nonce),
+	)
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+func (cfg *apiConfig) handlerOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if cfg.oidc == nil {
+		respondWithError(w, http.StatusNotFound, "SSO login is not configured", nil)
+		return
+	}
+
+	query := r.URL.Query()
+	if errorCode := query.Get("error"); errorCode != "" {
+		respondWithError(w, http.StatusUnauthorized, "SSO login failed: "+errorCode, errors.New(query.Get("error_description")))
+		return
+	}
+
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		respondWithError(w, http.StatusBadRequest, "Invalid SSO login state", err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:   oidcStateCookie,
+		Path:   "/api/oidc",
+		MaxAge: -1,
+	})
+
+	login, ok := cfg.oidc.logins.take(state)
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "SSO login expired, try again", nil)
+		return
+	}
+
+	token, err := cfg.oidc.oauth2.Exchange(r.Context(), query.Get("code"), oauth2.VerifierOption(login.codeVerifier))
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't exchange authorization code", err)
+		return
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Identity provider didn't return an ID token", nil)
+		return
+	}
+
+	idToken, err := cfg.oidc.verifier.Verify(r.Context(), rawIDToken)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't verify ID token", err)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(login.nonce)) != 1 {
+		respondWithError(w, http.StatusUnauthorized, "ID token nonce mismatch", nil)
+		return
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+	err = idToken.Claims(&claims)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't parse ID token claims", err)
+		return
+	}
+	if claims.Email == "" || !claims.EmailVerified {
+		respondWithError(w, http.StatusForbidden, "Identity provider didn't vouch for a verified email", nil)
+		return
+	}
+
+	user, err := cfg.findOrCreateOIDCUser(r.Context(), idToken.Issuer, idToken.Subject, claims.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign in SSO user", err)
+		return
+	}
+	if user.DisabledAt != nil {
+		cfg.audit(r, auditLogin(database.AuditOutcomeDenied, user.ID, "account disabled"))
+		respondWithError(w, http.StatusForbidden, "Account disabled", nil)
+		return
+	}
+	// The identity provider stands in for the password, not for the rest of
+	// the login: a locked account stays locked, and a second factor is
+	// still needed.
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		cfg.audit(r, auditLogin(database.AuditOutcomeFailure, user.ID, "account locked"))
+		respondWithRetryAfter(w, time.Until(*user.LockedUntil), "Account temporarily locked after too many failed logins")
+		return
+	}
+
+	// Hand the tokens to the web app in the URL fragment, which browsers
+	// never send to servers or write to access logs.
+	fragment := url.Values{}
+	if user.TOTPEnabledAt != nil {
+		challengeToken, err := auth.MakeMFAChallengeJWT(user.ID, cfg.jwtKeys, mfaChallengeLifetime)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create challenge token", err)
+			return
+		}
+		fragment.Set("mfa_required", "true")
+		fragment.Set("challenge_token", challengeToken)
+		http.Redirect(w, r, "/app/#"+fragment.Encode(), http.StatusFound)
+		return
+	}
+
+	accessToken, refreshToken, err := cfg.createSession(r, user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create session", err)
+		return
+	}
+	cfg.audit(r, auditLogin(database.AuditOutcomeSuccess, user.ID, "sso"))
+
+	fragment.Set("token", accessToken)
+	fragment.Set("refresh_token", refreshToken)
+	http.Redirect(w, r, "/app/#"+fragment.Encode(), http.StatusFound)
+}
+
+// findOrCreateOIDCUser returns the user linked to the identity, linking an
+// existing account with the same email or creating a new one as needed.
+func (cfg *apiConfig) findOrCreateOIDCUser(ctx context.Context, issuer, subject, email string) (database.User, error) {
+	user, err := cfg.db.WithContext(ctx).GetUserByIdentity(issuer, subject)
+	if err != nil {
+		return database.User{}, err
+	}
+	if user != nil {
+		return *user, nil
+	}
+
+	existing, err := cfg.db.WithContext(ctx).GetUserByEmail(email)
+	if err != nil {
+		return database.User{}, err
+	}
+	// SSO users don't get a usable password. They can set one through the
+	// password reset flow if they ever need it.
+	randomPassword, err := auth.MakeRefreshToken()
+	if err != nil {
+		return database.User{}, err
+	}
+	hashedPassword, err := auth.HashPassword(randomPassword)
+	if err != nil {
+		return database.User{}, err
+	}
+
+	if existing.ID != uuid.Nil {
+		user = &existing
+		// Nobody ever proved they owned the email on an unverified account,
+		// so don't let whoever registered it keep a way in.
+		if existing.VerifiedAt == nil {
+			err = cfg.db.WithContext(ctx).ReplaceUserPassword(existing.ID, hashedPassword)
+			if err != nil {
+				return database.User{}, err
+			}
+		}
+	} else {
+		user, err = cfg.db.WithContext(ctx).CreateUser(database.CreateUserParams{
+			Email:    email,
+			Password: hashedPassword,
+		})
+		if err != nil {
+			return database.User{}, err
+		}
+		contextLogger(ctx).Info("Created user for SSO identity", "user_id", user.ID, "subject", subject)
+	}
+
+	err = cfg.db.WithContext(ctx).CreateUserIdentity(user.ID, issuer, subject)
+	if err != nil {
+		return database.User{}, err
+	}
+	err = cfg.db.WithContext(ctx).MarkEmailVerified(user.ID)
+	if err != nil {
+		return database.User{}, err
+	}
+
+	return *user, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/openapi"
+)
+
+// maxJSONBodyBytes caps JSON request bodies, which are only ever a few
+// short fields.
+const maxJSONBodyBytes = 1 << 20
+
+func (cfg *apiConfig) handlerOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Write(openapi.Document())
+}
+
+// validateRequestBodies checks JSON request bodies against the OpenAPI
+// document before they reach their handlers, so handlers only ever decode
+// bodies of the right shape. Every problem with a body is reported at once,
+// field by field. This happens before the handler checks the access token,
+// so a bad body gets a 400 even without one.
+func validateRequestBodies(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		// Set here as well as by the mux so requests rejected below are
+		// still counted and traced by route.
+		r.Pattern = pattern
+		if !openapi.TakesJSON(pattern) {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxJSONBodyBytes))
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				respondWithError(w, http.StatusRequestEntityTooLarge, "Request body too large", err)
+				return
+			}
+			respondWithError(w, http.StatusBadRequest, "Couldn't read request body", err)
+			return
+		}
+
+		var validationErr *openapi.ValidationError
+		if err := openapi.ValidateRequest(pattern, body); errors.As(err, &validationErr) {
+			respondWithValidationError(w, validationErr)
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func respondWithValidationError(w http.ResponseWriter, err *openapi.ValidationError) {
+	type response struct {
+		Error  string               `json:"error"`
+		Fields []openapi.FieldError `json:"fields"`
+	}
+	responseLogger(w).Info("Responding with error", "status", http.StatusBadRequest, "error", err)
+	respondWithJSON(w, http.StatusBadRequest, response{
+		Error:  "Invalid request body",
+		Fields: err.Fields,
+	})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/openapi"
+)
+
+// registeredRoutes returns the patterns commandServe registers on its mux,
+// read from the source so the list can't drift from what's served.
+func registeredRoutes(t *testing.T) []string {
+	t.Helper()
+	file, err := parser.ParseFile(token.NewFileSet(), "main.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var routes []string
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Name.Name != "commandServe" {
+			continue
+		}
+		ast.Inspect(fn.Body, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok || (sel.Sel.Name != "Handle" && sel.Sel.Name != "HandleFunc") {
+				return true
+			}
+			if recv, ok := sel.X.(*ast.Ident); !ok || recv.Name != "mux" {
+				return true
+			}
+			lit, ok := call.Args[0].(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				t.Errorf("mux.%s pattern isn't a string literal", sel.Sel.Name)
+				return true
+			}
+			pattern, err := strconv.Unquote(lit.Value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			routes = append(routes, pattern)
+			return true
+		})
+	}
+	if len(routes) == 0 {
+		t.Fatal("found no routes in commandServe")
+	}
+	return routes
+}
+
+// documentedRoutes returns the document's operations as "METHOD /path".
+func documentedRoutes(t *testing.T) []string {
+	t.Helper()
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openapi.Document(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	var routes []string
+	for path, operations := range doc.Paths {
+		for method := range operations {
+			routes = append(routes, strings.ToUpper(method)+" "+path)
+		}
+	}
+	return routes
+}
+
+// documentedRoute turns a ServeMux pattern into the operation documenting
+// it. Patterns without a method are file servers, documented as GET, and a
+// trailing slash matches the rest of the path as {path}.
+func documentedRoute(pattern string) string {
+	method, path, ok := strings.Cut(pattern, " ")
+	if !ok {
+		method, path = "GET", pattern
+	}
+	if strings.HasSuffix(path, "/") {
+		path += "{path}"
+	}
+	return method + " " + path
+}
+
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+	documented := documentedRoutes(t)
+	var served []string
+	for _, pattern := range registeredRoutes(t) {
+		route := documentedRoute(pattern)
+		served = append(served, route)
+		if !slices.Contains(documented, route) {
+			t.Errorf("%s isn't in openapi.json", pattern)
+		}
+	}
+	for _, route := range documented {
+		if !slices.Contains(served, route) {
+			t.Errorf("openapi.json documents %s, which isn't served", route)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
+	"github.com/google/uuid"
+)
+
+const passwordResetTokenTTL = time.Hour
+
+func (cfg *apiConfig) handlerPasswordChange(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	type response struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+	if !cfg.rejectImpersonation(w, r) {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.NewPassword == "" {
+		respondWithError(w, http.StatusBadRequest, "New password is required", nil)
+		return
+	}
+
+	user, err := cfg.db.WithContext(r.Context()).GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	err = auth.CheckPasswordHash(params.CurrentPassword, user.Password)
+	if err != nil {
+		cfg.audit(r, auditEvent(auditPasswordChange, database.AuditOutcomeFailure, userID, auditTargetUser, userID))
+		respondWithError(w, http.StatusUnauthorized, "Incorrect password", err)
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(params.NewPassword)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+		return
+	}
+
+	// Sign out every session along with the change, so a failure can't leave
+	// the old sessions valid under the new password. The caller then gets a
+	// fresh refresh token so the session that changed it stays signed in.
+	err = cfg.db.WithContext(r.Context()).ReplaceUserPassword(userID, hashedPassword)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update password", err)
+		return
+	}
+	cfg.audit(r, auditEvent(auditPasswordChange, database.AuditOutcomeSuccess, userID, auditTargetUser, userID))
+
+	refreshToken, err := cfg.createRefreshToken(r, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		RefreshToken: refreshToken,
+	})
+}
+
+func (cfg *apiConfig) handlerPasswordResetRequest(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Email == "" {
+		respondWithError(w, http.StatusBadRequest, "Email is required", nil)
+		return
+	}
+
+	// Respond the same way, and as quickly, whether or not the account
+	// exists so the endpoint can't be used to discover registered emails.
+	// The email is sent after the response, so it mustn't be cancelled
+	// with the request, but it's still part of the request's trace.
+	ctx := context.WithoutCancel(r.Context())
+	logger := requestLogger(r)
+	cfg.lifecycle.goJob(func() {
+		err := cfg.sendPasswordResetEmail(ctx, params.Email)
+		if err != nil {
+			logger.Error("Couldn't send password reset email", "error", err)
+		}
+	})
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *apiConfig) sendPasswordResetEmail(ctx context.Context, email string) error {
+	user, err := cfg.db.WithContext(ctx).GetUserByEmail(email)
+	if err != nil {
+		return fmt.Errorf("couldn't look up user: %w", err)
+	}
+	if user.ID == uuid.Nil {
+		return nil
+	}
+
+	resetToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		return fmt.Errorf("couldn't create reset token: %w", err)
+	}
+
+	err = cfg.db.WithContext(ctx).CreatePasswordResetToken(database.CreatePasswordResetTokenParams{
+		TokenHash: auth.HashToken(resetToken),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().UTC().Add(passwordResetTokenTTL),
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't save reset token: %w", err)
+	}
+
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Tubely password",
+		Body: fmt.Sprintf(
+			"Someone asked to reset the password for your Tubely account.\n\n"+
+				"Your reset token is:\n\n    %s\n\n"+
+				"It expires in %.0f minutes. If you didn't ask for this, you can ignore this email.",
+			resetToken,
+			passwordResetTokenTTL.Minutes(),
+		),
+	})
+}
+
+func (cfg *apiConfig) handlerPasswordResetConfirm(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Token == "" || params.NewPassword == "" {
+		respondWithError(w, http.StatusBadRequest, "Token and new password are required", nil)
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(params.NewPassword)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+		return
+	}
+
+	userID, err := cfg.db.WithContext(r.Context()).ResetPassword(auth.HashToken(params.Token), hashedPassword)
+	if errors.Is(err, database.ErrInvalidResetToken) {
+		cfg.audit(r, auditEvent(auditPasswordReset, database.AuditOutcomeFailure, uuid.Nil, auditTargetUser, uuid.Nil))
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired reset token", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password", err)
+		return
+	}
+	cfg.audit(r, auditEvent(auditPasswordReset, database.AuditOutcomeSuccess, userID, auditTargetUser, userID))
+
+	w.WriteHeader(http.StatusNoContent)
+}
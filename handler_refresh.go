@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 )
 
 func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
@@ -18,21 +19,39 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := cfg.db.GetUserByRefreshToken(refreshToken)
+	user, err := cfg.db.WithContext(r.Context()).GetUserByRefreshToken(refreshToken)
 	if err != nil {
+		cfg.auditRefreshToken(r, auditSessionRefresh, database.AuditOutcomeFailure, refreshToken, "invalid, expired or revoked token")
 		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", err)
 		return
 	}
+	if user == nil {
+		cfg.auditRefreshToken(r, auditSessionRefresh, database.AuditOutcomeFailure, refreshToken, "invalid, expired or revoked token")
+		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", nil)
+		return
+	}
+	if user.DisabledAt != nil {
+		cfg.auditRefreshToken(r, auditSessionRefresh, database.AuditOutcomeDenied, refreshToken, "account disabled")
+		respondWithError(w, http.StatusForbidden, "Account disabled", nil)
+		return
+	}
+
+	err = cfg.db.WithContext(r.Context()).TouchRefreshToken(refreshToken, r.UserAgent(), clientIP(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update session", err)
+		return
+	}
 
 	accessToken, err := auth.MakeJWT(
 		user.ID,
-		cfg.jwtSecret,
+		cfg.jwtKeys,
 		time.Hour,
 	)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate token", err)
 		return
 	}
+	cfg.auditRefreshToken(r, auditSessionRefresh, database.AuditOutcomeSuccess, refreshToken, "")
 
 	respondWithJSON(w, http.StatusOK, response{
 		Token: accessToken,
@@ -46,11 +65,12 @@ func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = cfg.db.RevokeRefreshToken(refreshToken)
+	err = cfg.db.WithContext(r.Context()).RevokeRefreshToken(refreshToken)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session", err)
 		return
 	}
+	cfg.auditRefreshToken(r, auditSessionRevoke, database.AuditOutcomeSuccess, refreshToken, "")
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	totpIssuer           = "Tubely"
+	totpRecoveryCodes    = 10
+	mfaChallengeLifetime = 5 * time.Minute
+)
+
+func (cfg *apiConfig) handlerTOTPEnroll(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+	}
+
+	user, ok := cfg.getAuthenticatedUser(w, r)
+	if !ok {
+		return
+	}
+	if !cfg.rejectImpersonation(w, r) {
+		return
+	}
+	if user.TOTPEnabledAt != nil {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled", nil)
+		return
+	}
+
+	secret, err := auth.MakeTOTPSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create TOTP secret", err)
+		return
+	}
+
+	err = cfg.db.WithContext(r.Context()).SetPendingTOTPSecret(user.ID, secret)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save TOTP secret", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Secret: secret,
+		URI:    auth.TOTPURI(secret, totpIssuer, user.Email),
+	})
+}
+
+func (cfg *apiConfig) handlerTOTPConfirm(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	user, ok := cfg.getAuthenticatedUser(w, r)
+	if !ok {
+		return
+	}
+	if !cfg.rejectImpersonation(w, r) {
+		return
+	}
+	if user.TOTPEnabledAt != nil {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled", nil)
+		return
+	}
+	if user.TOTPSecret == nil {
+		respondWithError(w, http.StatusBadRequest, "Start two-factor enrollment first", nil)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	step, ok := auth.ValidateTOTP(*user.TOTPSecret, params.Code, time.Now(), user.TOTPLastStep)
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Invalid code", nil)
+		return
+	}
+
+	recoveryCodes, err := auth.MakeRecoveryCodes(totpRecoveryCodes)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create recovery codes", err)
+		return
+	}
+	recoveryCodeHashes := make([]string, 0, len(recoveryCodes))
+	for _, code := range recoveryCodes {
+		recoveryCodeHashes = append(recoveryCodeHashes, auth.HashToken(code))
+	}
+
+	err = cfg.db.WithContext(r.Context()).EnableTOTP(user.ID, step, recoveryCodeHashes)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable two-factor authentication", err)
+		return
+	}
+	cfg.audit(r, auditEvent(auditTOTPEnable, database.AuditOutcomeSuccess, user.ID, auditTargetUser, user.ID))
+
+	respondWithJSON(w, http.StatusOK, response{
+		RecoveryCodes: recoveryCodes,
+	})
+}
+
+func (cfg *apiConfig) handlerTOTPDisable(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Password string `json:"password"`
+	}
+
+	user, ok := cfg.getAuthenticatedUser(w, r)
+	if !ok {
+		return
+	}
+	if !cfg.rejectImpersonation(w, r) {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	err = auth.CheckPasswordHash(params.Password, user.Password)
+	if err != nil {
+		cfg.audit(r, auditEvent(auditTOTPDisable, database.AuditOutcomeFailure, user.ID, auditTargetUser, user.ID))
+		respondWithError(w, http.StatusUnauthorized, "Incorrect password", err)
+		return
+	}
+
+	err = cfg.db.WithContext(r.Context()).DisableTOTP(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't disable two-factor authentication", err)
+		return
+	}
+	cfg.audit(r, auditEvent(auditTOTPDisable, database.AuditOutcomeSuccess, user.ID, auditTargetUser, user.ID))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerLoginMFA is the second step of a two-factor login. It exchanges the
+// challenge token from handlerLogin plus a TOTP or recovery code for the
+// usual access and refresh tokens.
+func (cfg *apiConfig) handlerLoginMFA(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+
+	ip := clientIP(r)
+	if retryAfter := cfg.loginThrottle.blockedFor(ip); retryAfter > 0 {
+		respondWithRetryAfter(w, retryAfter, "Too many failed logins, try again later")
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	userID, err := auth.ValidateMFAChallengeJWT(params.ChallengeToken, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired challenge token", err)
+		return
+	}
+
+	user, err := cfg.db.WithContext(r.Context()).GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil || user.TOTPEnabledAt == nil || user.TOTPSecret == nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired challenge token", nil)
+		return
+	}
+	// The challenge token shows the password was right, so saying the
+	// account is locked gives nothing away.
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		cfg.audit(r, auditLogin(database.AuditOutcomeFailure, user.ID, "account locked"))
+		respondWithRetryAfter(w, time.Until(*user.LockedUntil), "Account temporarily locked after too many failed logins")
+		return
+	}
+	if user.DisabledAt != nil {
+		cfg.audit(r, auditLogin(database.AuditOutcomeDenied, user.ID, "account disabled"))
+		respondWithError(w, http.StatusForbidden, "Account disabled", nil)
+		return
+	}
+
+	var verified bool
+	switch {
+	case params.Code != "":
+		step, ok := auth.ValidateTOTP(*user.TOTPSecret, params.Code, time.Now(), user.TOTPLastStep)
+		if ok {
+			verified, err = cfg.db.WithContext(r.Context()).UseTOTPStep(user.ID, step)
+		}
+	case params.RecoveryCode != "":
+		codeHash := auth.HashToken(auth.NormalizeRecoveryCode(params.RecoveryCode))
+		verified, err = cfg.db.WithContext(r.Context()).UseRecoveryCode(user.ID, codeHash)
+	default:
+		respondWithError(w, http.StatusBadRequest, "A code or recovery code is required", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify code", err)
+		return
+	}
+	if !verified {
+		cfg.loginThrottle.recordFailure(ip)
+		cfg.recordFailedLogin(r, *user)
+		cfg.audit(r, auditLogin(database.AuditOutcomeFailure, user.ID, "wrong two-factor code"))
+		respondWithError(w, http.StatusUnauthorized, "Invalid code", nil)
+		return
+	}
+
+	cfg.completeLogin(w, r, *user)
+}
+
+func (cfg *apiConfig) respondWithMFAChallenge(w http.ResponseWriter, user database.User) {
+	type response struct {
+		MFARequired    bool   `json:"mfa_required"`
+		ChallengeToken string `json:"challenge_token"`
+	}
+
+	challengeToken, err := auth.MakeMFAChallengeJWT(user.ID, cfg.jwtKeys, mfaChallengeLifetime)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create challenge token", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		MFARequired:    true,
+		ChallengeToken: challengeToken,
+	})
+}
+
+// getAuthenticatedUser validates the request's access token and loads the
+// user it belongs to. On failure it writes the error response and returns
+// false.
+func (cfg *apiConfig) getAuthenticatedUser(w http.ResponseWriter, r *http.Request) (database.User, bool) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.User{}, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.User{}, false
+	}
+
+	user, err := cfg.db.WithContext(r.Context()).GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return database.User{}, false
+	}
+	if user == nil || user.ID == uuid.Nil {
+		respondWithError(w, http.StatusUnauthorized, "User not found", nil)
+		return database.User{}, false
+	}
+
+	return *user, true
+}
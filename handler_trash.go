@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerTrashRetrieve(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	videos, err := cfg.db.WithContext(r.Context()).GetTrashedVideos(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve trash", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, videos)
+}
+
+func (cfg *apiConfig) handlerVideoRestore(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.WithContext(r.Context()).GetTrashedVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not in trash", nil)
+		return
+	}
+	if video.UserID != userID {
+		cfg.audit(r, auditEvent(auditVideoRestore, database.AuditOutcomeDenied, userID, auditTargetVideo, videoID))
+		respondWithError(w, http.StatusForbidden, "You can't restore this video", nil)
+		return
+	}
+
+	err = cfg.db.WithContext(r.Context()).RestoreVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't restore video", err)
+		return
+	}
+	cfg.audit(r, auditEvent(auditVideoRestore, database.AuditOutcomeSuccess, userID, auditTargetVideo, videoID))
+
+	video, err = cfg.db.WithContext(r.Context()).GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get restored video", err)
+		return
+	}
+
+	w.Header().Set("ETag", videoETag(video))
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// runTrashPurger periodically hard-deletes videos that have been in the trash
+// for longer than cfg.trashRetention. It returns when ctx is done.
+func (cfg *apiConfig) runTrashPurger(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := cfg.purgeTrash(ctx); err != nil {
+			cfg.logger.Error("Error purging trash", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *apiConfig) purgeTrash(ctx context.Context) error {
+	ctx, span := tracer.Start(ctx, "purgeTrash")
+	defer span.End()
+
+	cutoff := time.Now().Add(-cfg.trashRetention)
+	videos, err := cfg.db.WithContext(ctx).GetVideosTrashedBefore(cutoff)
+	if err != nil {
+		return err
+	}
+
+	for _, video := range videos {
+		// The row goes first, and only while it's still trashed, so a video
+		// restored since it was read keeps both its row and its files.
+		purged, err := cfg.db.WithContext(ctx).PurgeTrashedVideo(video.ID, cutoff)
+		if err != nil {
+			cfg.logger.Error("Couldn't purge trashed video", "video_id", video.ID, "error", err)
+			continue
+		}
+		if !purged {
+			continue
+		}
+		if err := cfg.deleteVideoAssets(ctx, video); err != nil {
+			cfg.logger.Error("Couldn't delete assets of purged video", "video_id", video.ID, "error", err)
+		}
+		cfg.logger.Info("Purged trashed video", "video_id", video.ID)
+	}
+
+	return nil
+}
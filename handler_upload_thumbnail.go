@@ -1,12 +1,13 @@
 package main
 
 import (
-	"fmt"
+	"errors"
 	"io"
 	"net/http"
 	"os"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 	"github.com/google/uuid"
 )
 
@@ -24,27 +25,42 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
 	}
 
-	video, err := cfg.db.GetVideo(videoID)
+	video, err := cfg.db.WithContext(r.Context()).GetVideo(videoID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
 		return
 	}
 	if video.UserID != userID {
+		cfg.audit(r, auditEvent(auditThumbnailUpload, database.AuditOutcomeDenied, userID, auditTargetVideo, videoID))
 		respondWithError(w, http.StatusUnauthorized, "Not authorized to update this video", nil)
 		return
 	}
+	if !checkIfMatch(r, video) {
+		respondWithError(w, http.StatusPreconditionFailed, "Video has been modified", nil)
+		return
+	}
+
+	if !cfg.checkUploadAllowed(w, r, userID) {
+		return
+	}
+	overQuota := auditEvent(auditThumbnailUpload, database.AuditOutcomeFailure, userID, auditTargetVideo, videoID)
+	overQuota.Detail = "storage quota"
+	if !cfg.limitUpload(w, r, userID, video.ThumbnailSize, maxThumbnailUploadBytes) {
+		cfg.audit(r, overQuota)
+		return
+	}
 
-	fmt.Println("uploading thumbnail for video", videoID, "by user", userID)
+	requestLogger(r).Info("Uploading thumbnail")
 
 	const maxMemory = 10 << 20
 	if err := r.ParseMultipartForm(maxMemory); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Couldn't parse thumbnail form file", err)
+		respondWithUploadError(w, http.StatusBadRequest, "Couldn't parse thumbnail form file", err)
 		return
 	}
 
@@ -87,18 +103,49 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 	}
 	defer localFile.Close()
 
-	_, err = io.Copy(localFile, formFile)
+	thumbnailSize, err := io.Copy(localFile, formFile)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to copy thumbnail contents to file", err)
+		os.Remove(assetDiskPath)
+		respondWithUploadError(w, http.StatusInternalServerError, "Failed to copy thumbnail contents to file", err)
+		return
+	}
+	if !cfg.checkStorageQuota(w, r, userID, video.ThumbnailSize, thumbnailSize) {
+		os.Remove(assetDiskPath)
+		cfg.audit(r, overQuota)
 		return
 	}
 
+	previousURL := video.ThumbnailURL
 	url := cfg.getAssetURL(assetFilename)
 	video.ThumbnailURL = &url
-	if err := cfg.db.UpdateVideo(video); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Couldn't update video information in database", err)
+	video.ThumbnailSize = thumbnailSize
+	err = cfg.db.WithContext(r.Context()).UpdateVideo(video)
+	if err != nil {
+		os.Remove(assetDiskPath)
+		if errors.Is(err, database.ErrVideoConflict) {
+			respondWithVideoConflict(w, r, err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video information in database", err)
+		return
+	}
+	if previousURL != nil {
+		if previousFilename, ok := cfg.thumbnailAssetFilename(*previousURL); ok {
+			err := os.Remove(cfg.getAssetDiskPath(previousFilename))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				requestLogger(r).Error("Couldn't remove replaced thumbnail", "file", previousFilename, "error", err)
+			}
+		}
+	}
+	cfg.audit(r, auditEvent(auditThumbnailUpload, database.AuditOutcomeSuccess, userID, auditTargetVideo, videoID))
+	observeUpload("thumbnail", mediaType, thumbnailSize)
+
+	video, err = cfg.db.WithContext(r.Context()).GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get updated video", err)
 		return
 	}
 
+	w.Header().Set("ETag", videoETag(video))
 	respondWithJSON(w, http.StatusOK, video)
 }
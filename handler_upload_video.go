@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,16 +11,17 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 	"github.com/google/uuid"
 )
 
 func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
-	const uploadLimit = 1 << 30
-	r.Body = http.MaxBytesReader(w, r.Body, uploadLimit)
+	r.Body = http.MaxBytesReader(w, r.Body, maxVideoUploadBytes+multipartOverhead)
 
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
@@ -34,23 +36,38 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
 	}
 
-	video, err := cfg.db.GetVideo(videoID)
+	video, err := cfg.db.WithContext(r.Context()).GetVideo(videoID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't find video", err)
 		return
 	}
 	if video.UserID != userID {
+		cfg.audit(r, auditEvent(auditVideoUpload, database.AuditOutcomeDenied, userID, auditTargetVideo, videoID))
 		respondWithError(w, http.StatusUnauthorized, "Not authorized to update this video", nil)
 		return
 	}
+	if !checkIfMatch(r, video) {
+		respondWithError(w, http.StatusPreconditionFailed, "Video has been modified", nil)
+		return
+	}
+
+	if !cfg.checkUploadAllowed(w, r, userID) {
+		return
+	}
+	overQuota := auditEvent(auditVideoUpload, database.AuditOutcomeFailure, userID, auditTargetVideo, videoID)
+	overQuota.Detail = "storage quota"
+	if !cfg.limitUpload(w, r, userID, video.VideoSize, maxVideoUploadBytes) {
+		cfg.audit(r, overQuota)
+		return
+	}
 
-	fmt.Println("uploading video file for video ID", videoID, "by user", userID)
+	requestLogger(r).Info("Uploading video file")
 
 	// const maxMemory = 10 << 30
 	// if err := r.ParseMultipartForm(maxMemory); err != nil {
@@ -60,7 +77,7 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 
 	formFile, header, err := r.FormFile("video")
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Couldn't get video form file", err)
+		respondWithUploadError(w, http.StatusBadRequest, "Couldn't get video form file", err)
 		return
 	}
 	defer formFile.Close()
@@ -87,7 +104,7 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	localTempFile, err := os.CreateTemp("", assetFilename)
+	localTempFile, err := os.CreateTemp(cfg.tempDir, assetFilename)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Failed to create video file", err)
 		return
@@ -95,9 +112,9 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 	defer os.Remove(localTempFile.Name())
 	defer localTempFile.Close()
 
-	_, err = io.Copy(localTempFile, formFile)
+	uploadSize, err := io.Copy(localTempFile, formFile)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to copy video contents to file", err)
+		respondWithUploadError(w, http.StatusInternalServerError, "Failed to copy video contents to file", err)
 		return
 	}
 
@@ -108,13 +125,13 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 	}
 
 	// FIXME Ignore errors and default to `aspectRatio = "other"`
-	aspectRatio, err := getVideoAspectRatioName(localTempFile.Name())
+	aspectRatio, err := getVideoAspectRatioName(r.Context(), localTempFile.Name())
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Failed to get aspect ratio", err)
 		return
 	}
 
-	localProcessedFilepath, err := processVideoForFastStart(localTempFile.Name())
+	localProcessedFilepath, err := processVideoForFastStart(r.Context(), localTempFile.Name())
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Failed to process video file", err)
 		return
@@ -128,8 +145,19 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 	}
 	defer localProcessedFile.Close()
 
+	processedFileInfo, err := localProcessedFile.Stat()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to stat processed video file", err)
+		return
+	}
+	if !cfg.checkStorageQuota(w, r, userID, video.VideoSize, processedFileInfo.Size()) {
+		cfg.audit(r, overQuota)
+		return
+	}
+
 	assetKey := aspectRatio + "/" + assetFilename
 
+	putStart := time.Now()
 	_, err = cfg.s3Client.PutObject(
 		r.Context(),
 		&s3.PutObjectInput{
@@ -139,31 +167,57 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 			ContentType: aws.String(mediaType),
 		},
 	)
+	observeS3Request("PutObject", putStart, err)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Failed to store video file in AWS S3", err)
 		return
 	}
 
+	previousURL := video.VideoURL
 	url := cfg.getS3AssetURL(assetKey)
 	video.VideoURL = &url
-	if err := cfg.db.UpdateVideo(video); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Couldn't update video information in database", err)
+	video.VideoSize = processedFileInfo.Size()
+	err = cfg.db.WithContext(r.Context()).UpdateVideo(video)
+	if err != nil {
+		// Nothing refers to the new object, so nothing else would ever
+		// delete it.
+		cfg.deleteS3Object(context.WithoutCancel(r.Context()), assetKey)
+		if errors.Is(err, database.ErrVideoConflict) {
+			respondWithVideoConflict(w, r, err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video information in database", err)
 		return
 	}
+	// The replaced object no longer counts toward the quota, so it mustn't
+	// stay in the bucket either.
+	if previousURL != nil {
+		if previousKey, ok := cfg.videoAssetKey(*previousURL); ok {
+			cfg.deleteS3Object(context.WithoutCancel(r.Context()), previousKey)
+		}
+	}
+	cfg.audit(r, auditEvent(auditVideoUpload, database.AuditOutcomeSuccess, userID, auditTargetVideo, videoID))
+	observeUpload("video", mediaType, uploadSize)
 
-	fmt.Println("Saved video file to AWS S3, available at", url)
+	requestLogger(r).Info("Saved video file to S3", "url", url)
+
+	video, err = cfg.db.WithContext(r.Context()).GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get updated video", err)
+		return
+	}
 
+	w.Header().Set("ETag", videoETag(video))
 	respondWithJSON(w, http.StatusOK, video)
 }
 
 func getPercentError(actual float64, expected float64) float64 {
-	percentError := math.Abs(actual-expected) / math.Abs(expected) * 100.0
-	fmt.Println("percent error:", percentError)
-	return percentError
+	return math.Abs(actual-expected) / math.Abs(expected) * 100.0
 }
 
-func getVideoAspectRatioName(filePath string) (string, error) {
-	ffprobeCmd := exec.Command(
+func getVideoAspectRatioName(ctx context.Context, filePath string) (string, error) {
+	ffprobeCmd := exec.CommandContext(
+		ctx,
 		"ffprobe",
 		"-v", "error",
 		"-print_format", "json",
@@ -173,7 +227,7 @@ func getVideoAspectRatioName(filePath string) (string, error) {
 	var ffprobeOut bytes.Buffer
 	ffprobeCmd.Stdout = &ffprobeOut
 
-	if err := ffprobeCmd.Run(); err != nil {
+	if err := runCommand(ctx, ffprobeCmd); err != nil {
 		return "", fmt.Errorf("error running ffprobe: %w", err)
 	}
 
@@ -212,10 +266,11 @@ func getVideoAspectRatioName(filePath string) (string, error) {
 	return aspectRatioName, nil
 }
 
-func processVideoForFastStart(filePath string) (string, error) {
+func processVideoForFastStart(ctx context.Context, filePath string) (string, error) {
 	processedFilePath := filePath + ".processing"
 
-	ffmpegCmd := exec.Command(
+	ffmpegCmd := exec.CommandContext(
+		ctx,
 		"ffmpeg",
 		"-i", filePath,
 		"-c", "copy",
@@ -227,7 +282,8 @@ func processVideoForFastStart(filePath string) (string, error) {
 	var stderr bytes.Buffer
 	ffmpegCmd.Stderr = &stderr
 
-	if err := ffmpegCmd.Run(); err != nil {
+	if err := runCommand(ctx, ffmpegCmd); err != nil {
+		os.Remove(processedFilePath)
 		return "", fmt.Errorf("error processing video: %s, %v", stderr.String(), err)
 	}
 
@@ -236,6 +292,7 @@ func processVideoForFastStart(filePath string) (string, error) {
 		return "", fmt.Errorf("could not stat processed file: %v", err)
 	}
 	if fileInfo.Size() == 0 {
+		os.Remove(processedFilePath)
 		return "", fmt.Errorf("processed file is empty")
 	}
 
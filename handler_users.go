@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/mail"
+	"strings"
+	"time"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
@@ -18,7 +23,7 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 	params := parameters{}
 	err := decoder.Decode(&params)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
 		return
 	}
 
@@ -26,6 +31,10 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		respondWithError(w, http.StatusBadRequest, "Email and password are required", nil)
 		return
 	}
+	if err := validateEmail(params.Email); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid email address", err)
+		return
+	}
 
 	hashedPassword, err := auth.HashPassword(params.Password)
 	if err != nil {
@@ -33,7 +42,7 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	user, err := cfg.db.CreateUser(database.CreateUserParams{
+	user, err := cfg.db.WithContext(r.Context()).CreateUser(database.CreateUserParams{
 		Email:    params.Email,
 		Password: hashedPassword,
 	})
@@ -41,6 +50,111 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create user", err)
 		return
 	}
+	cfg.audit(r, auditEvent(auditUserCreate, database.AuditOutcomeSuccess, user.ID, auditTargetUser, user.ID))
+
+	ctx := context.WithoutCancel(r.Context())
+	logger := requestLogger(r)
+	cfg.lifecycle.goJob(func() {
+		if err := cfg.sendVerificationEmail(ctx, *user); err != nil {
+			logger.Error("Couldn't send verification email", "user_id", user.ID, "error", err)
+		}
+	})
+
+	respondWithJSON(w, http.StatusCreated, user.Public())
+}
+
+// validateEmail accepts a bare address such as "name@example.com", rejecting
+// display names and anything net/mail can't parse.
+func validateEmail(email string) error {
+	address, err := mail.ParseAddress(email)
+	if err != nil {
+		return err
+	}
+	if address.Address != email {
+		return fmt.Errorf("'%s' is not a bare email address", email)
+	}
+	if !strings.Contains(email[strings.LastIndex(email, "@")+1:], ".") {
+		return fmt.Errorf("'%s' has no top-level domain", email)
+	}
+	return nil
+}
+
+// reauthWindow is how recently an SSO user must have logged in for that to
+// confirm deleting their account in place of a password.
+const reauthWindow = 5 * time.Minute
+
+func (cfg *apiConfig) handlerUsersDelete(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Password string `json:"password"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+	if !cfg.rejectImpersonation(w, r) {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	user, err := cfg.db.WithContext(r.Context()).GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	err = auth.CheckPasswordHash(params.Password, user.Password)
+	if err != nil {
+		// SSO users may never have had a password anyone knows, so for them
+		// having just logged in again confirms it instead.
+		linked, linkErr := cfg.db.WithContext(r.Context()).HasUserIdentity(userID)
+		if linkErr != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get user identities", linkErr)
+			return
+		}
+		authTime, authErr := auth.GetAuthTime(token, cfg.jwtKeys)
+		if !linked || authErr != nil || time.Since(authTime) > reauthWindow {
+			cfg.audit(r, auditEvent(auditUserDelete, database.AuditOutcomeFailure, userID, auditTargetUser, userID))
+			msg := "Incorrect password"
+			if linked {
+				msg = fmt.Sprintf("Incorrect password. To confirm without one, log in with SSO again and delete the account within %d minutes", int(reauthWindow.Minutes()))
+			}
+			respondWithError(w, http.StatusUnauthorized, msg, err)
+			return
+		}
+	}
+
+	videos, err := cfg.db.WithContext(r.Context()).DeleteUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete user", err)
+		return
+	}
+	cfg.audit(r, auditEvent(auditUserDelete, database.AuditOutcomeSuccess, userID, auditTargetUser, userID))
+
+	// The account is gone at this point, so asset cleanup failures are
+	// logged rather than reported to the client.
+	for _, video := range videos {
+		if err := cfg.deleteVideoAssets(r.Context(), video); err != nil {
+			requestLogger(r).Error("Couldn't delete assets of deleted user's video", "video_id", video.ID, "error", err)
+		}
+	}
 
-	respondWithJSON(w, http.StatusCreated, user)
+	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,36 @@
+// Package api holds the JSON shapes the API responds with. The server builds
+// its responses from them and the Go client decodes into them, so the two
+// can't drift apart. It imports nothing but uuid, keeping the client free of
+// the server's database driver and telemetry.
+package api
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Video struct {
+	ID           uuid.UUID `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	Title        string    `json:"title"`
+	Description  string    `json:"description"`
+	UserID       uuid.UUID `json:"user_id"`
+	ThumbnailURL *string   `json:"thumbnail_url"`
+	VideoURL     *string   `json:"video_url"`
+	// DeletedAt is when the video was moved to the trash, for videos in it.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+type User struct {
+	ID            uuid.UUID  `json:"id"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	Email         string     `json:"email"`
+	VerifiedAt    *time.Time `json:"verified_at"`
+	TOTPEnabledAt *time.Time `json:"totp_enabled_at"`
+	// Role is "user", "moderator" or "admin".
+	Role       string     `json:"role"`
+	DisabledAt *time.Time `json:"disabled_at"`
+}
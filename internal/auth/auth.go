@@ -2,11 +2,13 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -18,6 +20,9 @@ type TokenType string
 
 const (
 	TokenTypeAccess TokenType = "tubely-access"
+	// TokenTypeMFAChallenge proves the password step of a two-factor login
+	// succeeded. It can't be used as an access token.
+	TokenTypeMFAChallenge TokenType = "tubely-mfa-challenge"
 )
 
 var ErrNoAuthHeaderIncluded = errors.New("no auth header included in request")
@@ -34,27 +39,152 @@ func CheckPasswordHash(password, hash string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 }
 
+// dummyPasswordHash is a real bcrypt hash that no login is expected to match.
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, err := bcrypt.GenerateFromPassword([]byte("tubely-dummy-password"), bcrypt.DefaultCost)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
+// CheckPasswordHashDummy spends as long as CheckPasswordHash would on a real
+// account. Login calls it for unknown emails so response timing doesn't
+// reveal which emails are registered.
+func CheckPasswordHashDummy(password string) {
+	bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+}
+
 func MakeJWT(
 	userID uuid.UUID,
-	tokenSecret string,
+	keys *KeySet,
+	expiresIn time.Duration,
+) (string, error) {
+	return makeJWT(TokenTypeAccess, userID, keys, expiresIn)
+}
+
+// loginClaims are the claims of an access token issued by a login.
+// AuthTime is when the user logged in, as in OpenID Connect.
+type loginClaims struct {
+	jwt.RegisteredClaims
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+}
+
+// MakeLoginJWT makes an access token for a user who has just logged in. It
+// records when in an auth_time claim, which tokens issued by a refresh
+// don't have, so that a recent login can stand in for a password.
+func MakeLoginJWT(
+	userID uuid.UUID,
+	keys *KeySet,
+	expiresIn time.Duration,
+) (string, error) {
+	now := jwt.NewNumericDate(time.Now().UTC())
+	return keys.sign(loginClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    string(TokenTypeAccess),
+			IssuedAt:  now,
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+			Subject:   userID.String(),
+		},
+		AuthTime: now,
+	})
+}
+
+// GetAuthTime returns when the user logged in to get an access token, or
+// the zero time if it wasn't issued by a login. It doesn't validate the
+// token; call ValidateJWT first.
+func GetAuthTime(tokenString string, keys *KeySet) (time.Time, error) {
+	claims := loginClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, &claims, keys.keyFunc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if claims.AuthTime == nil {
+		return time.Time{}, nil
+	}
+	return claims.AuthTime.Time, nil
+}
+
+func ValidateJWT(tokenString string, keys *KeySet) (uuid.UUID, error) {
+	return validateJWT(TokenTypeAccess, tokenString, keys)
+}
+
+func MakeMFAChallengeJWT(
+	userID uuid.UUID,
+	keys *KeySet,
 	expiresIn time.Duration,
 ) (string, error) {
-	signingKey := []byte(tokenSecret)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    string(TokenTypeAccess),
+	return makeJWT(TokenTypeMFAChallenge, userID, keys, expiresIn)
+}
+
+func ValidateMFAChallengeJWT(tokenString string, keys *KeySet) (uuid.UUID, error) {
+	return validateJWT(TokenTypeMFAChallenge, tokenString, keys)
+}
+
+// ImpersonationClaims are the claims of an access token an admin was issued
+// to act as another user. Act identifies the admin, as in RFC 8693.
+type ImpersonationClaims struct {
+	jwt.RegisteredClaims
+	Act struct {
+		Subject string `json:"sub"`
+	} `json:"act"`
+}
+
+// MakeImpersonationJWT makes an access token for userID that records actorID
+// as the one actually using it.
+func MakeImpersonationJWT(
+	userID uuid.UUID,
+	actorID uuid.UUID,
+	keys *KeySet,
+	expiresIn time.Duration,
+) (string, error) {
+	claims := ImpersonationClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    string(TokenTypeAccess),
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			Subject:   userID.String(),
+		},
+	}
+	claims.Act.Subject = actorID.String()
+	return keys.sign(claims)
+}
+
+// GetImpersonator returns the admin an access token was issued to, or
+// uuid.Nil if it's the user's own token. It doesn't validate the token; call
+// ValidateJWT first.
+func GetImpersonator(tokenString string, keys *KeySet) (uuid.UUID, error) {
+	claims := ImpersonationClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, &claims, keys.keyFunc)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if claims.Act.Subject == "" {
+		return uuid.Nil, nil
+	}
+	return uuid.Parse(claims.Act.Subject)
+}
+
+func makeJWT(
+	tokenType TokenType,
+	userID uuid.UUID,
+	keys *KeySet,
+	expiresIn time.Duration,
+) (string, error) {
+	return keys.sign(jwt.RegisteredClaims{
+		Issuer:    string(tokenType),
 		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
 		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
 		Subject:   userID.String(),
 	})
-	return token.SignedString(signingKey)
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
+func validateJWT(tokenType TokenType, tokenString string, keys *KeySet) (uuid.UUID, error) {
 	claimsStruct := jwt.RegisteredClaims{}
 	token, err := jwt.ParseWithClaims(
 		tokenString,
 		&claimsStruct,
-		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
+		keys.keyFunc,
 	)
 	if err != nil {
 		return uuid.Nil, err
@@ -69,7 +199,7 @@ func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
 	if err != nil {
 		return uuid.Nil, err
 	}
-	if issuer != string(TokenTypeAccess) {
+	if issuer != string(tokenType) {
 		return uuid.Nil, errors.New("invalid issuer")
 	}
 
@@ -102,6 +232,13 @@ func MakeRefreshToken() (string, error) {
 	return hex.EncodeToString(token), nil
 }
 
+// HashToken returns the hex SHA-256 of a random single-use token, for
+// storing tokens that only ever need to be looked up, never recovered.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 func GetAPIKey(headers http.Header) (string, error) {
 	authHeader := headers.Get("Authorization")
 	if authHeader == "" {
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestLoginJWTRecordsAuthTime(t *testing.T) {
+	keys := NewKeySet("secret")
+	userID := uuid.New()
+
+	token, err := MakeLoginJWT(userID, keys, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ValidateJWT(token, keys)
+	if err != nil {
+		t.Fatalf("ValidateJWT: %v", err)
+	}
+	if got != userID {
+		t.Errorf("ValidateJWT = %s, want %s", got, userID)
+	}
+	authTime, err := GetAuthTime(token, keys)
+	if err != nil {
+		t.Fatalf("GetAuthTime: %v", err)
+	}
+	if since := time.Since(authTime); since < 0 || since > time.Minute {
+		t.Errorf("GetAuthTime = %s, want about now", authTime)
+	}
+}
+
+func TestRefreshedJWTHasNoAuthTime(t *testing.T) {
+	keys := NewKeySet("secret")
+	token, err := MakeJWT(uuid.New(), keys, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authTime, err := GetAuthTime(token, keys)
+	if err != nil {
+		t.Fatalf("GetAuthTime: %v", err)
+	}
+	if !authTime.IsZero() {
+		t.Errorf("GetAuthTime = %s, want the zero time", authTime)
+	}
+}
+
+func TestGetAuthTimeRejectsForgedToken(t *testing.T) {
+	token, err := MakeLoginJWT(uuid.New(), NewKeySet("other secret"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetAuthTime(token, NewKeySet("secret")); err == nil {
+		t.Error("GetAuthTime accepted a token signed with another key")
+	}
+}
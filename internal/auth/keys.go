@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// KeySet signs tokens with a single current key and verifies them with any
+// key it knows about, so tokens signed by a retired key stay valid while it's
+// being rotated out.
+//
+// A KeySet can also hold a legacy HS256 secret. Tokens without a kid header
+// are verified with it, and it signs new tokens when no asymmetric signing
+// key is set.
+type KeySet struct {
+	hmacSecret   []byte
+	signing      *jwtKey
+	verification map[string]*jwtKey
+}
+
+type jwtKey struct {
+	id      string
+	method  jwt.SigningMethod
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+// JWK is the public half of a verification key in JSON Web Key format.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewKeySet returns a KeySet that signs and verifies with the HS256 secret
+// until a signing key is set. An empty secret disables HS256 entirely.
+func NewKeySet(hmacSecret string) *KeySet {
+	ks := &KeySet{
+		verification: map[string]*jwtKey{},
+	}
+	if hmacSecret != "" {
+		ks.hmacSecret = []byte(hmacSecret)
+	}
+	return ks
+}
+
+// SetSigningKey parses a PEM encoded Ed25519 or RSA private key and makes it
+// the key new tokens are signed with. It's also added as a verification key.
+func (ks *KeySet) SetSigningKey(privateKeyPEM []byte) error {
+	key, err := parseJWTKey(privateKeyPEM)
+	if err != nil {
+		return err
+	}
+	if key.private == nil {
+		return errors.New("signing key must be a private key")
+	}
+	ks.signing = key
+	ks.verification[key.id] = key
+	return nil
+}
+
+// AddVerificationKey parses a PEM encoded Ed25519 or RSA key, public or
+// private, and accepts tokens signed by it.
+func (ks *KeySet) AddVerificationKey(keyPEM []byte) error {
+	key, err := parseJWTKey(keyPEM)
+	if err != nil {
+		return err
+	}
+	ks.verification[key.id] = key
+	return nil
+}
+
+// JWKS returns the public verification keys. The HS256 secret is never
+// included.
+func (ks *KeySet) JWKS() JWKS {
+	jwks := JWKS{
+		Keys: []JWK{},
+	}
+	for _, key := range ks.verification {
+		jwks.Keys = append(jwks.Keys, key.jwk())
+	}
+	sort.Slice(jwks.Keys, func(i, j int) bool {
+		return jwks.Keys[i].KeyID < jwks.Keys[j].KeyID
+	})
+	return jwks
+}
+
+func (ks *KeySet) sign(claims jwt.Claims) (string, error) {
+	if ks.signing == nil {
+		if ks.hmacSecret == nil {
+			return "", errors.New("no signing key configured")
+		}
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(ks.hmacSecret)
+	}
+
+	token := jwt.NewWithClaims(ks.signing.method, claims)
+	token.Header["kid"] = ks.signing.id
+	return token.SignedString(ks.signing.private)
+}
+
+// keyFunc picks the verification key for a token by its kid, and refuses
+// tokens whose alg doesn't match that key.
+func (ks *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, hasKID := token.Header["kid"].(string)
+	if !hasKID {
+		if ks.hmacSecret == nil || token.Method != jwt.SigningMethodHS256 {
+			return nil, errors.New("token has no kid")
+		}
+		return ks.hmacSecret, nil
+	}
+
+	key, ok := ks.verification[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("signing key %q doesn't use %s", kid, token.Method.Alg())
+	}
+	return key.public, nil
+}
+
+func parseJWTKey(keyPEM []byte) (*jwtKey, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var parsed any
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &jwtKey{}
+	switch k := parsed.(type) {
+	case ed25519.PrivateKey:
+		key.method, key.private, key.public = jwt.SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.method, key.public = jwt.SigningMethodEdDSA, k
+	case *rsa.PrivateKey:
+		key.method, key.private, key.public = jwt.SigningMethodRS256, k, k.Public()
+	case *rsa.PublicKey:
+		key.method, key.public = jwt.SigningMethodRS256, k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, use Ed25519 or RSA", parsed)
+	}
+	if rsaKey, ok := key.public.(*rsa.PublicKey); ok && rsaKey.N.BitLen() < 2048 {
+		return nil, errors.New("RSA keys must be at least 2048 bits")
+	}
+
+	key.id = key.thumbprint()
+	return key, nil
+}
+
+func (k *jwtKey) jwk() JWK {
+	jwk := JWK{
+		KeyID:     k.id,
+		Use:       "sig",
+		Algorithm: k.method.Alg(),
+	}
+	switch public := k.public.(type) {
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	}
+	return jwk
+}
+
+// thumbprint is the RFC 7638 JWK thumbprint of the public key, which gives
+// every key a stable kid without having to configure one.
+func (k *jwtKey) thumbprint() string {
+	jwk := k.jwk()
+	var members any
+	switch jwk.KeyType {
+	case "OKP":
+		members = struct {
+			Curve   string `json:"crv"`
+			KeyType string `json:"kty"`
+			X       string `json:"x"`
+		}{jwk.Curve, jwk.KeyType, jwk.X}
+	case "RSA":
+		members = struct {
+			E       string `json:"e"`
+			KeyType string `json:"kty"`
+			N       string `json:"n"`
+		}{jwk.E, jwk.KeyType, jwk.N}
+	}
+	dat, _ := json.Marshal(members)
+	sum := sha256.Sum256(dat)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,278 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func privateKeyPEM(t *testing.T, key any) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func publicKeyPEM(t *testing.T, key any) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func newEd25519PEM(t *testing.T) []byte {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return privateKeyPEM(t, key)
+}
+
+func newRSAPEM(t *testing.T) []byte {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return privateKeyPEM(t, key)
+}
+
+func accessClaims(userID uuid.UUID) jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{
+		Issuer:    string(TokenTypeAccess),
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		Subject:   userID.String(),
+	}
+}
+
+func TestKeySetSignsAndVerifies(t *testing.T) {
+	tests := []struct {
+		name string
+		pem  func(*testing.T) []byte
+	}{
+		{"Ed25519", newEd25519PEM},
+		{"RSA", newRSAPEM},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			keys := NewKeySet("")
+			if err := keys.SetSigningKey(tc.pem(t)); err != nil {
+				t.Fatal(err)
+			}
+			userID := uuid.New()
+			token, err := MakeJWT(userID, keys, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ValidateJWT(token, keys)
+			if err != nil {
+				t.Fatalf("ValidateJWT: %v", err)
+			}
+			if got != userID {
+				t.Errorf("ValidateJWT = %s, want %s", got, userID)
+			}
+		})
+	}
+}
+
+// TestKeySetRejectsAlgorithmConfusion checks that a token can't pick a
+// different algorithm than its key uses, in particular HS256 with the
+// public key as the HMAC secret.
+func TestKeySetRejectsAlgorithmConfusion(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		private any
+		public  any
+	}{
+		{"Ed25519", edKey, edKey.Public()},
+		{"RSA", rsaKey, rsaKey.Public()},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// The HS256 secret is set so the only thing stopping the forged
+			// token is the kid's algorithm check.
+			keys := NewKeySet("legacy-secret")
+			if err := keys.SetSigningKey(privateKeyPEM(t, tc.private)); err != nil {
+				t.Fatal(err)
+			}
+			kid := keys.signing.id
+
+			forged := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims(uuid.New()))
+			forged.Header["kid"] = kid
+			signed, err := forged.SignedString(publicKeyPEM(t, tc.public))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ValidateJWT(signed, keys); err == nil {
+				t.Error("ValidateJWT accepted an HS256 token for an asymmetric key")
+			}
+		})
+	}
+
+	t.Run("RS256 for an Ed25519 key", func(t *testing.T) {
+		keys := NewKeySet("")
+		if err := keys.SetSigningKey(privateKeyPEM(t, edKey)); err != nil {
+			t.Fatal(err)
+		}
+		forged := jwt.NewWithClaims(jwt.SigningMethodRS256, accessClaims(uuid.New()))
+		forged.Header["kid"] = keys.signing.id
+		signed, err := forged.SignedString(rsaKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ValidateJWT(signed, keys); err == nil {
+			t.Error("ValidateJWT accepted an RS256 token for an Ed25519 key")
+		}
+	})
+}
+
+func TestKeySetRejectsHS256WithoutSecret(t *testing.T) {
+	keys := NewKeySet("")
+	if err := keys.SetSigningKey(newEd25519PEM(t)); err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims(uuid.New())).SignedString([]byte(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateJWT(token, keys); err == nil {
+		t.Error("ValidateJWT accepted an HS256 token with HS256 disabled")
+	}
+}
+
+func TestKeySetRejectsUnknownKID(t *testing.T) {
+	signer := NewKeySet("")
+	if err := signer.SetSigningKey(newEd25519PEM(t)); err != nil {
+		t.Fatal(err)
+	}
+	verifier := NewKeySet("")
+	if err := verifier.SetSigningKey(newEd25519PEM(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := MakeJWT(uuid.New(), signer, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateJWT(token, verifier); err == nil {
+		t.Error("ValidateJWT accepted a token signed by an unknown key")
+	}
+}
+
+func TestKeySetVerifiesRotatedOutKey(t *testing.T) {
+	oldKey := newEd25519PEM(t)
+	keys := NewKeySet("")
+	if err := keys.SetSigningKey(oldKey); err != nil {
+		t.Fatal(err)
+	}
+	userID := uuid.New()
+	oldToken, err := MakeJWT(userID, keys, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotate: a new signing key, with the old one kept for verification
+	// only, as the server is configured while rotating.
+	rotated := NewKeySet("")
+	if err := rotated.SetSigningKey(newRSAPEM(t)); err != nil {
+		t.Fatal(err)
+	}
+	if err := rotated.AddVerificationKey(oldKey); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ValidateJWT(oldToken, rotated)
+	if err != nil {
+		t.Fatalf("ValidateJWT rejected a token from the rotated out key: %v", err)
+	}
+	if got != userID {
+		t.Errorf("ValidateJWT = %s, want %s", got, userID)
+	}
+	if n := len(rotated.JWKS().Keys); n != 2 {
+		t.Errorf("JWKS has %d keys, want 2", n)
+	}
+
+	newToken, err := MakeJWT(userID, rotated, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateJWT(newToken, keys); err == nil {
+		t.Error("the old key set accepted a token from the new key")
+	}
+}
+
+func TestJWKSOmitsHMACSecret(t *testing.T) {
+	keys := NewKeySet("legacy-secret")
+	if n := len(keys.JWKS().Keys); n != 0 {
+		t.Errorf("JWKS has %d keys, want 0", n)
+	}
+}
+
+// TestThumbprint checks kids against the RFC 7638 section 3.1 and RFC 8037
+// appendix A.3 examples.
+func TestThumbprint(t *testing.T) {
+	decode := func(s string) []byte {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	tests := []struct {
+		name string
+		key  *jwtKey
+		want string
+	}{
+		{
+			name: "RSA",
+			key: &jwtKey{
+				method: jwt.SigningMethodRS256,
+				public: &rsa.PublicKey{
+					N: new(big.Int).SetBytes(decode("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")),
+					E: 65537,
+				},
+			},
+			want: "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs",
+		},
+		{
+			name: "Ed25519",
+			key: &jwtKey{
+				method: jwt.SigningMethodEdDSA,
+				public: ed25519.PublicKey(decode("11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo")),
+			},
+			want: "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.key.thumbprint(); got != tc.want {
+				t.Errorf("thumbprint = %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
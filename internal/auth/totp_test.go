@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key from RFC 6238 appendix B, base32 encoded.
+var rfc6238Secret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+// rfc6238Vectors are the SHA-1 test vectors from RFC 6238 appendix B. The
+// RFC gives eight digit codes; six digit codes are their last six digits.
+var rfc6238Vectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	for _, tc := range rfc6238Vectors {
+		got := totpCode([]byte("12345678901234567890"), tc.unix/totpPeriod)
+		if got != tc.code {
+			t.Errorf("totpCode at %d = %s, want %s", tc.unix, got, tc.code)
+		}
+	}
+}
+
+func TestValidateTOTPRFC6238(t *testing.T) {
+	for _, tc := range rfc6238Vectors {
+		step, ok := ValidateTOTP(rfc6238Secret, tc.code, time.Unix(tc.unix, 0), 0)
+		if !ok {
+			t.Errorf("ValidateTOTP rejected %s at %d", tc.code, tc.unix)
+			continue
+		}
+		if want := tc.unix / totpPeriod; step != want {
+			t.Errorf("ValidateTOTP at %d matched step %d, want %d", tc.unix, step, want)
+		}
+	}
+}
+
+func TestValidateTOTPSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / totpPeriod
+	key := []byte("12345678901234567890")
+
+	tests := []struct {
+		name   string
+		offset int64
+		want   bool
+	}{
+		{"two periods behind", -2, false},
+		{"one period behind", -1, true},
+		{"current period", 0, true},
+		{"one period ahead", 1, true},
+		{"two periods ahead", 2, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			code := totpCode(key, current+tc.offset)
+			step, ok := ValidateTOTP(rfc6238Secret, code, now, 0)
+			if ok != tc.want {
+				t.Fatalf("ValidateTOTP = %v, want %v", ok, tc.want)
+			}
+			if ok && step != current+tc.offset {
+				t.Errorf("matched step %d, want %d", step, current+tc.offset)
+			}
+		})
+	}
+}
+
+func TestValidateTOTPRejectsReplay(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / totpPeriod
+	code := totpCode([]byte("12345678901234567890"), current)
+
+	step, ok := ValidateTOTP(rfc6238Secret, code, now, 0)
+	if !ok {
+		t.Fatal("ValidateTOTP rejected a fresh code")
+	}
+	if _, ok := ValidateTOTP(rfc6238Secret, code, now, step); ok {
+		t.Error("ValidateTOTP accepted a code from the last used step")
+	}
+	if _, ok := ValidateTOTP(rfc6238Secret, code, now, step+1); ok {
+		t.Error("ValidateTOTP accepted a code from before the last used step")
+	}
+
+	// An earlier step in the window is still refused once a later one has
+	// been used.
+	previous := totpCode([]byte("12345678901234567890"), current-1)
+	if _, ok := ValidateTOTP(rfc6238Secret, previous, now, current); ok {
+		t.Error("ValidateTOTP accepted an earlier step after a later one was used")
+	}
+}
+
+func TestValidateTOTPRejectsMalformed(t *testing.T) {
+	now := time.Unix(59, 0)
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+	}{
+		{"wrong code", rfc6238Secret, "000000"},
+		{"too short", rfc6238Secret, "28708"},
+		{"eight digits", rfc6238Secret, "94287082"},
+		{"empty", rfc6238Secret, ""},
+		{"bad secret", "not base32!", "287082"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, ok := ValidateTOTP(tc.secret, tc.code, now, 0); ok {
+				t.Errorf("ValidateTOTP(%q, %q) accepted", tc.secret, tc.code)
+			}
+		})
+	}
+}
+
+func TestValidateTOTPAcceptsLowerCaseSecretAndSpaces(t *testing.T) {
+	if _, ok := ValidateTOTP(strings.ToLower(rfc6238Secret), " 287082 ", time.Unix(59, 0), 0); !ok {
+		t.Error("ValidateTOTP rejected a lower case secret or a padded code")
+	}
+}
+
+func TestMakeTOTPSecret(t *testing.T) {
+	secret, err := MakeTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		t.Fatalf("secret %q isn't base32: %v", secret, err)
+	}
+	if len(key) != 20 {
+		t.Errorf("secret is %d bytes, want 20", len(key))
+	}
+}
+
+func TestRecoveryCodesRoundTrip(t *testing.T) {
+	codes, err := MakeRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 10 {
+		t.Fatalf("got %d codes, want 10", len(codes))
+	}
+
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Errorf("code %q isn't of the form abcde-fghij", code)
+		}
+		if seen[code] {
+			t.Errorf("code %q repeated", code)
+		}
+		seen[code] = true
+
+		for _, typed := range []string{
+			code,
+			strings.ToUpper(code),
+			strings.ReplaceAll(code, "-", ""),
+			"  " + strings.ToUpper(strings.ReplaceAll(code, "-", "")) + "\n",
+		} {
+			if got := NormalizeRecoveryCode(typed); got != code {
+				t.Errorf("NormalizeRecoveryCode(%q) = %q, want %q", typed, got, code)
+			}
+		}
+	}
+}
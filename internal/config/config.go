@@ -0,0 +1,177 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// Config holds every setting the server reads at startup.
+//
+// Each field's config tag names its environment variable, followed by
+// "required" if it must be set and "secret" if its value mustn't be printed.
+// The same setting is read from a config file under the lower-cased name
+// (db_path) and from a flag with dashes instead (--db-path).
+type Config struct {
+	DBPath string `config:"DB_PATH,required" usage:"path to the SQLite database"`
+
+	JWTSecret               string   `config:"JWT_SECRET,secret" usage:"HMAC secret for signing access tokens"`
+	JWTSigningKeyFile       string   `config:"JWT_SIGNING_KEY_FILE" usage:"Ed25519 or RSA private key (PEM) to sign access tokens with instead of JWT_SECRET"`
+	JWTVerificationKeyFiles []string `config:"JWT_VERIFICATION_KEY_FILES" usage:"comma separated PEM files of old keys that still verify tokens"`
+
+	Platform     string `config:"PLATFORM,required" usage:"\"dev\" allows the reset command to wipe the database"`
+	FilepathRoot string `config:"FILEPATH_ROOT,required" usage:"directory the web app is served from"`
+	AssetsRoot   string `config:"ASSETS_ROOT,required" usage:"directory thumbnails are stored in"`
+	S3Bucket     string `config:"S3_BUCKET,required" usage:"S3 bucket videos are stored in"`
+	S3Region     string `config:"S3_REGION,required" usage:"AWS region of the S3 bucket"`
+	S3CfDistro   string `config:"S3_CF_DISTRO,required" usage:"CloudFront distribution videos are served from"`
+	Port         string `config:"PORT,required" usage:"port to listen on"`
+
+	LogFormat string `config:"LOG_FORMAT" default:"text" usage:"\"text\" or \"json\" log lines"`
+	LogLevel  string `config:"LOG_LEVEL" default:"info" usage:"minimum level to log: debug, info, warn or error"`
+
+	TrashRetention  time.Duration `config:"TRASH_RETENTION" default:"720h" usage:"how long deleted videos stay in the trash before being purged"`
+	ShutdownTimeout time.Duration `config:"SHUTDOWN_TIMEOUT" default:"30s" usage:"how long to wait for in-flight requests on SIGTERM before cancelling them"`
+
+	Mailer       string `config:"MAILER" default:"log" usage:"\"log\" writes emails to stderr or MAIL_LOG_FILE, \"smtp\" sends them"`
+	MailLogFile  string `config:"MAIL_LOG_FILE" usage:"file the log mailer appends emails to"`
+	SMTPHost     string `config:"SMTP_HOST" usage:"SMTP server host"`
+	SMTPPort     string `config:"SMTP_PORT" default:"587" usage:"SMTP server port"`
+	SMTPUsername string `config:"SMTP_USERNAME" usage:"SMTP username"`
+	SMTPPassword string `config:"SMTP_PASSWORD,secret" usage:"SMTP password"`
+	MailFrom     string `config:"MAIL_FROM" usage:"From address of emails sent over SMTP"`
+
+	RequireVerifiedEmail bool `config:"REQUIRE_VERIFIED_EMAIL" usage:"block uploads until a user verifies their email"`
+	ReadinessErrors      bool `config:"READINESS_ERRORS" usage:"say why a check failed in /readyz responses, not just in the log"`
+
+	QuotaUserStorageBytes      QuotaLimit `config:"QUOTA_USER_STORAGE_BYTES" usage:"default storage quota for users, in bytes"`
+	QuotaUserVideoCount        QuotaLimit `config:"QUOTA_USER_VIDEO_COUNT" usage:"default video count quota for users"`
+	QuotaModeratorStorageBytes QuotaLimit `config:"QUOTA_MODERATOR_STORAGE_BYTES" usage:"default storage quota for moderators, in bytes"`
+	QuotaModeratorVideoCount   QuotaLimit `config:"QUOTA_MODERATOR_VIDEO_COUNT" usage:"default video count quota for moderators"`
+	QuotaAdminStorageBytes     QuotaLimit `config:"QUOTA_ADMIN_STORAGE_BYTES" usage:"default storage quota for admins, in bytes"`
+	QuotaAdminVideoCount       QuotaLimit `config:"QUOTA_ADMIN_VIDEO_COUNT" usage:"default video count quota for admins"`
+
+	OIDCIssuerURL    string `config:"OIDC_ISSUER_URL" usage:"OpenID Connect provider to enable \"Login with SSO\" through"`
+	OIDCClientID     string `config:"OIDC_CLIENT_ID" usage:"OpenID Connect client ID"`
+	OIDCClientSecret string `config:"OIDC_CLIENT_SECRET,secret" usage:"OpenID Connect client secret"`
+	OIDCRedirectURL  string `config:"OIDC_REDIRECT_URL" usage:"OpenID Connect callback URL (default http://localhost:PORT/api/oidc/callback)"`
+
+	// File is the config file the settings were read from, if any.
+	File string
+
+	sources map[string]string
+}
+
+// QuotaLimit overrides one of a role's default quota limits. The zero value
+// leaves the default alone.
+type QuotaLimit struct {
+	Set       bool
+	Unlimited bool
+	Value     int64
+}
+
+func (l *QuotaLimit) UnmarshalText(text []byte) error {
+	if string(text) == "unlimited" {
+		*l = QuotaLimit{Set: true, Unlimited: true}
+		return nil
+	}
+	value, err := strconv.ParseInt(string(text), 10, 64)
+	if err != nil || value < 0 {
+		return fmt.Errorf("must be a non-negative integer or \"unlimited\", got %q", text)
+	}
+	*l = QuotaLimit{Set: true, Value: value}
+	return nil
+}
+
+func (l QuotaLimit) String() string {
+	switch {
+	case !l.Set:
+		return ""
+	case l.Unlimited:
+		return "unlimited"
+	default:
+		return strconv.FormatInt(l.Value, 10)
+	}
+}
+
+// Limit returns the limit, or nil if it's unlimited.
+func (l QuotaLimit) Limit() *int64 {
+	if l.Unlimited {
+		return nil
+	}
+	value := l.Value
+	return &value
+}
+
+// Require checks that each of the named settings is set, for commands that
+// only need some of what the server does.
+func (c Config) Require(keys ...string) error {
+	var errs []error
+	for _, key := range keys {
+		for _, f := range fields {
+			if f.key == key && isZero(f.value(&c)) {
+				errs = append(errs, fmt.Errorf("%s must be set", key))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Validate checks that the settings the server needs are present and make
+// sense together. It reports every problem it finds, not just the first.
+func (c Config) Validate() error {
+	var errs []error
+	for _, f := range fields {
+		if f.required && isZero(f.value(&c)) {
+			errs = append(errs, fmt.Errorf("%s must be set", f.key))
+		}
+	}
+
+	if c.JWTSecret == "" && c.JWTSigningKeyFile == "" {
+		errs = append(errs, errors.New("JWT_SECRET or JWT_SIGNING_KEY_FILE must be set"))
+	}
+
+	if c.Port != "" {
+		if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+			errs = append(errs, fmt.Errorf("PORT must be a port number, got %q", c.Port))
+		}
+	}
+
+	switch c.LogFormat {
+	case "text", "json":
+	default:
+		errs = append(errs, fmt.Errorf("LOG_FORMAT must be \"text\" or \"json\", got %q", c.LogFormat))
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		errs = append(errs, fmt.Errorf("LOG_LEVEL must be debug, info, warn or error, got %q", c.LogLevel))
+	}
+
+	if c.TrashRetention <= 0 {
+		errs = append(errs, fmt.Errorf("TRASH_RETENTION must be positive, got %s", c.TrashRetention))
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("SHUTDOWN_TIMEOUT must be positive, got %s", c.ShutdownTimeout))
+	}
+
+	switch c.Mailer {
+	case "log":
+	case "smtp":
+		if c.SMTPHost == "" {
+			errs = append(errs, errors.New("SMTP_HOST must be set when MAILER is \"smtp\""))
+		}
+		if c.MailFrom == "" {
+			errs = append(errs, errors.New("MAIL_FROM must be set when MAILER is \"smtp\""))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("MAILER must be \"log\" or \"smtp\", got %q", c.Mailer))
+	}
+
+	if c.OIDCIssuerURL != "" && c.OIDCClientID == "" {
+		errs = append(errs, errors.New("OIDC_CLIENT_ID must be set when OIDC_ISSUER_URL is"))
+	}
+
+	return errors.Join(errs...)
+}
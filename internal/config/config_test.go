@@ -0,0 +1,358 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearEnv unsets every setting's environment variable for the test, so the
+// environment the tests run in can't leak into them.
+func clearEnv(t *testing.T) {
+	t.Helper()
+	for _, f := range fields {
+		t.Setenv(f.key, "")
+	}
+	t.Setenv("CONFIG_FILE", "")
+}
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func load(t *testing.T, args ...string) (Config, error) {
+	t.Helper()
+	fs := flag.NewFlagSet("tubely", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return Load(fs, args)
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	tests := []struct {
+		name       string
+		file       string
+		env        string
+		flag       string
+		want       string
+		wantSource string
+	}{
+		{
+			name:       "default",
+			want:       "info",
+			wantSource: sourceDefault,
+		},
+		{
+			name:       "file over default",
+			file:       "warn",
+			want:       "warn",
+			wantSource: sourceFile,
+		},
+		{
+			name:       "env over file",
+			file:       "warn",
+			env:        "error",
+			want:       "error",
+			wantSource: sourceEnv,
+		},
+		{
+			name:       "flag over env",
+			file:       "warn",
+			env:        "error",
+			flag:       "debug",
+			want:       "debug",
+			wantSource: sourceFlag,
+		},
+		{
+			name:       "flag over file",
+			file:       "warn",
+			flag:       "debug",
+			want:       "debug",
+			wantSource: sourceFlag,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			var args []string
+			if tt.file != "" {
+				args = append(args, "--config", writeConfigFile(t, "tubely.yaml", "log_level: "+tt.file+"\n"))
+			}
+			if tt.env != "" {
+				t.Setenv("LOG_LEVEL", tt.env)
+			}
+			if tt.flag != "" {
+				args = append(args, "--log-level", tt.flag)
+			}
+
+			conf, err := load(t, args...)
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if conf.LogLevel != tt.want {
+				t.Errorf("LogLevel = %q, want %q", conf.LogLevel, tt.want)
+			}
+			if got := conf.sources["LOG_LEVEL"]; got != tt.wantSource {
+				t.Errorf("source = %q, want %q", got, tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestLoadEmptyEnvIsUnset(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("CONFIG_FILE", writeConfigFile(t, "tubely.yaml", "port: \"8092\"\n"))
+
+	conf, err := load(t)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if conf.Port != "8092" || conf.sources["PORT"] != sourceFile {
+		t.Errorf("Port = %q from %q, want \"8092\" from the file", conf.Port, conf.sources["PORT"])
+	}
+}
+
+func TestLoadFileFormats(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{
+			name: "tubely.yaml",
+			content: `port: 8092
+require_verified_email: true
+trash_retention: 1h
+jwt_verification_key_files: [old.pem, older.pem]
+quota_user_video_count: unlimited
+`,
+		},
+		{
+			name: "tubely.toml",
+			content: `port = 8092
+require_verified_email = true
+trash_retention = "1h"
+jwt_verification_key_files = ["old.pem", "older.pem"]
+quota_user_video_count = "unlimited"
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			conf, err := load(t, "--config", writeConfigFile(t, tt.name, tt.content))
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if conf.Port != "8092" {
+				t.Errorf("Port = %q, want \"8092\"", conf.Port)
+			}
+			if !conf.RequireVerifiedEmail {
+				t.Error("RequireVerifiedEmail = false, want true")
+			}
+			if conf.TrashRetention != time.Hour {
+				t.Errorf("TrashRetention = %s, want 1h", conf.TrashRetention)
+			}
+			if want := []string{"old.pem", "older.pem"}; !reflect.DeepEqual(conf.JWTVerificationKeyFiles, want) {
+				t.Errorf("JWTVerificationKeyFiles = %q, want %q", conf.JWTVerificationKeyFiles, want)
+			}
+			if want := (QuotaLimit{Set: true, Unlimited: true}); conf.QuotaUserVideoCount != want {
+				t.Errorf("QuotaUserVideoCount = %+v, want %+v", conf.QuotaUserVideoCount, want)
+			}
+		})
+	}
+}
+
+func TestLoadFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		wantErr string
+	}{
+		{
+			name:    "unknown key",
+			file:    "tubely.yaml",
+			content: "prot: 8092\n",
+			wantErr: `unknown setting "prot"`,
+		},
+		{
+			name:    "environment variable name as key",
+			file:    "tubely.yaml",
+			content: "PORT: 8092\n",
+			wantErr: `unknown setting "PORT"`,
+		},
+		{
+			name:    "mistyped duration",
+			file:    "tubely.yaml",
+			content: "trash_retention: soon\n",
+			wantErr: "TRASH_RETENTION (from file): must be a duration",
+		},
+		{
+			name:    "mistyped bool",
+			file:    "tubely.toml",
+			content: "require_verified_email = \"maybe\"\n",
+			wantErr: "REQUIRE_VERIFIED_EMAIL (from file): must be true or false",
+		},
+		{
+			name:    "list of numbers",
+			file:    "tubely.yaml",
+			content: "jwt_verification_key_files: [1, 2]\n",
+			wantErr: "JWT_VERIFICATION_KEY_FILES (from file): list items must be strings",
+		},
+		{
+			name:    "nested table",
+			file:    "tubely.toml",
+			content: "[port]\nvalue = 8092\n",
+			wantErr: "PORT (from file): must be a string, number, boolean or list of strings",
+		},
+		{
+			name:    "unsupported extension",
+			file:    "tubely.json",
+			content: `{"port": 8092}`,
+			wantErr: "must be .yaml, .yml or .toml",
+		},
+		{
+			name:    "invalid syntax",
+			file:    "tubely.yaml",
+			content: "port: [8092\n",
+			wantErr: "couldn't parse config file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			_, err := load(t, "--config", writeConfigFile(t, tt.file, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Load() error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	clearEnv(t)
+	_, err := load(t, "--config", filepath.Join(t.TempDir(), "missing.yaml"))
+	if err == nil || !strings.Contains(err.Error(), "couldn't read config file") {
+		t.Errorf("Load() error = %v, want one about reading the file", err)
+	}
+}
+
+func TestLoadReportsEveryError(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("TRASH_RETENTION", "soon")
+	t.Setenv("QUOTA_USER_VIDEO_COUNT", "-1")
+	file := writeConfigFile(t, "tubely.yaml", "log_format: json\nbogus: 1\nshutdown_timeout: later\n")
+
+	conf, err := load(t, "--config", file, "--require-verified-email=maybe", "--port", "8092")
+	if err == nil {
+		t.Fatal("Load() error = nil, want an error")
+	}
+	for _, want := range []string{
+		"TRASH_RETENTION (from env)",
+		"QUOTA_USER_VIDEO_COUNT (from env)",
+		`unknown setting "bogus"`,
+		"SHUTDOWN_TIMEOUT (from file)",
+		"REQUIRE_VERIFIED_EMAIL (from flag)",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Load() error = %v, want it to mention %s", err, want)
+		}
+	}
+
+	// The settings that could be read are still returned, and the ones that
+	// couldn't keep their defaults.
+	if conf.Port != "8092" || conf.LogFormat != "json" {
+		t.Errorf("Port, LogFormat = %q, %q, want \"8092\", \"json\"", conf.Port, conf.LogFormat)
+	}
+	if conf.TrashRetention != 720*time.Hour {
+		t.Errorf("TrashRetention = %s, want the 720h default", conf.TrashRetention)
+	}
+}
+
+func TestValidateReportsEveryError(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("MAILER", "smtp")
+	t.Setenv("LOG_FORMAT", "xml")
+	t.Setenv("PORT", "99999")
+	conf, err := load(t)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	err = conf.Validate()
+	if err == nil {
+		t.Fatal("Validate() error = nil, want an error")
+	}
+	for _, want := range []string{
+		"DB_PATH must be set",
+		"S3_BUCKET must be set",
+		"JWT_SECRET or JWT_SIGNING_KEY_FILE must be set",
+		`PORT must be a port number, got "99999"`,
+		`LOG_FORMAT must be "text" or "json"`,
+		"SMTP_HOST must be set",
+		"MAIL_FROM must be set",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate() error = %v, want it to mention %s", err, want)
+		}
+	}
+}
+
+func TestPrintRedactsSecrets(t *testing.T) {
+	clearEnv(t)
+	var secrets []string
+	for _, f := range fields {
+		if f.secret {
+			value := "hunter2-" + strings.ToLower(f.key)
+			t.Setenv(f.key, value)
+			secrets = append(secrets, value)
+		}
+	}
+	if len(secrets) == 0 {
+		t.Fatal("no settings are tagged secret")
+	}
+	t.Setenv("DB_PATH", "./tubely.db")
+
+	conf, err := load(t)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	var out bytes.Buffer
+	if err := conf.Print(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, secret := range secrets {
+		if strings.Contains(out.String(), secret) {
+			t.Errorf("Print() output contains %q:\n%s", secret, out.String())
+		}
+	}
+	lines := make(map[string][]string)
+	for _, line := range strings.Split(out.String(), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			lines[fields[0]] = fields[1:]
+		}
+	}
+	for _, f := range fields {
+		got := lines[f.key]
+		switch {
+		case f.secret && !reflect.DeepEqual(got, []string{"[redacted]", sourceEnv}):
+			t.Errorf("%s printed as %q, want it redacted", f.key, got)
+		case f.key == "DB_PATH" && !reflect.DeepEqual(got, []string{"./tubely.db", sourceEnv}):
+			t.Errorf("DB_PATH printed as %q, want its value", got)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package config
+
+import (
+	"encoding"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Where a setting's value came from, in increasing order of precedence.
+const (
+	sourceDefault = "default"
+	sourceFile    = "file"
+	sourceEnv     = "env"
+	sourceFlag    = "flag"
+)
+
+// field describes one of Config's settings, as declared by its struct tags.
+type field struct {
+	key      string
+	index    int
+	required bool
+	secret   bool
+	def      string
+	usage    string
+}
+
+var fields = configFields()
+
+func configFields() []field {
+	var fields []field
+	t := reflect.TypeFor[Config]()
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		tag, ok := sf.Tag.Lookup("config")
+		if !ok {
+			continue
+		}
+		key, options, _ := strings.Cut(tag, ",")
+		f := field{
+			key:   key,
+			index: i,
+			def:   sf.Tag.Get("default"),
+			usage: sf.Tag.Get("usage"),
+		}
+		for _, option := range strings.Split(options, ",") {
+			switch option {
+			case "required":
+				f.required = true
+			case "secret":
+				f.secret = true
+			}
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+func (f field) value(c *Config) reflect.Value {
+	return reflect.ValueOf(c).Elem().Field(f.index)
+}
+
+func (f field) fileKey() string {
+	return strings.ToLower(f.key)
+}
+
+func (f field) flagName() string {
+	return strings.ReplaceAll(f.fileKey(), "_", "-")
+}
+
+type rawValue struct {
+	value  string
+	source string
+}
+
+// Load reads the configuration from, in increasing order of precedence,
+// defaults, a config file, environment variables and command line flags.
+// The config file is named by the --config flag or the CONFIG_FILE variable,
+// and may be YAML (.yaml or .yml) or TOML (.toml). An empty environment
+// variable counts as unset.
+//
+// Load adds a flag for every setting to fs and parses args with it. Every
+// setting that couldn't be read is reported in the returned error, along
+// with a Config holding the rest. Call Validate to check the result is
+// complete.
+func Load(fs *flag.FlagSet, args []string) (Config, error) {
+	flagValues := make(map[string]string)
+	for _, f := range fields {
+		set := func(value string) error {
+			flagValues[f.key] = value
+			return nil
+		}
+		usage := fmt.Sprintf("%s (%s)", f.usage, f.key)
+		if f.value(&Config{}).Kind() == reflect.Bool {
+			fs.BoolFunc(f.flagName(), usage, set)
+		} else {
+			fs.Func(f.flagName(), usage, set)
+		}
+	}
+	configFile := fs.String("config", "", "YAML or TOML file to read settings from (CONFIG_FILE)")
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	c := Config{
+		File:    *configFile,
+		sources: make(map[string]string),
+	}
+	if c.File == "" {
+		c.File = os.Getenv("CONFIG_FILE")
+	}
+
+	var errs []error
+	raw := make(map[string]rawValue)
+	for _, f := range fields {
+		if f.def != "" {
+			raw[f.key] = rawValue{f.def, sourceDefault}
+		}
+	}
+	if c.File != "" {
+		values, err := readFile(c.File)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		for key, value := range values {
+			raw[key] = rawValue{value, sourceFile}
+		}
+	}
+	for _, f := range fields {
+		if value := os.Getenv(f.key); value != "" {
+			raw[f.key] = rawValue{value, sourceEnv}
+		}
+		if value, ok := flagValues[f.key]; ok {
+			raw[f.key] = rawValue{value, sourceFlag}
+		}
+	}
+
+	for _, f := range fields {
+		r, ok := raw[f.key]
+		if !ok {
+			continue
+		}
+		if err := setValue(f.value(&c), r.value); err != nil {
+			errs = append(errs, fmt.Errorf("%s (from %s): %w", f.key, r.source, err))
+			// Keep the default so Validate doesn't complain about the same
+			// setting again.
+			if f.def != "" {
+				setValue(f.value(&c), f.def)
+			}
+			continue
+		}
+		c.sources[f.key] = r.source
+	}
+	return c, errors.Join(errs...)
+}
+
+// readFile reads a config file's settings, keyed by environment variable.
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read config file: %w", err)
+	}
+
+	var settings map[string]any
+	switch ext := filepath.Ext(path); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &settings)
+	case ".toml":
+		err = toml.Unmarshal(data, &settings)
+	default:
+		return nil, fmt.Errorf("config file %s must be .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse config file %s: %w", path, err)
+	}
+
+	keys := make(map[string]string, len(fields))
+	for _, f := range fields {
+		keys[f.fileKey()] = f.key
+	}
+
+	var errs []error
+	values := make(map[string]string, len(settings))
+	for name, setting := range settings {
+		key, ok := keys[name]
+		if !ok {
+			errs = append(errs, fmt.Errorf("unknown setting %q in config file %s", name, path))
+			continue
+		}
+		if setting == nil {
+			continue
+		}
+		value, err := fileValue(setting)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s (from file): %w", key, err))
+			continue
+		}
+		values[key] = value
+	}
+	return values, errors.Join(errs...)
+}
+
+// fileValue turns a value decoded from a config file into the string it
+// would have been given as in the environment.
+func fileValue(setting any) (string, error) {
+	switch v := setting.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int, int64, uint64:
+		return fmt.Sprint(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return "", errors.New("list items must be strings")
+			}
+			items[i] = s
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", errors.New("must be a string, number, boolean or list of strings")
+	}
+}
+
+func setValue(v reflect.Value, s string) error {
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+	switch v.Interface().(type) {
+	case string:
+		v.SetString(s)
+	case bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("must be true or false, got %q", s)
+		}
+		v.SetBool(b)
+	case time.Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("must be a duration such as 30s or 720h, got %q", s)
+		}
+		v.SetInt(int64(d))
+	case []string:
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
+
+func formatValue(v reflect.Value) string {
+	switch value := v.Interface().(type) {
+	case string:
+		return value
+	case bool:
+		return strconv.FormatBool(value)
+	case []string:
+		return strings.Join(value, ",")
+	case fmt.Stringer:
+		return value.String()
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+func isZero(v reflect.Value) bool {
+	if v.Kind() == reflect.Slice {
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+// Print writes the effective value of every setting and where it came from,
+// with secrets redacted.
+func (c Config) Print(w io.Writer) error {
+	if c.File != "" {
+		fmt.Fprintf(w, "# config file: %s\n", c.File)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SETTING\tVALUE\tSOURCE")
+	for _, f := range fields {
+		value := formatValue(f.value(&c))
+		if f.secret && value != "" {
+			value = "[redacted]"
+		}
+		source, ok := c.sources[f.key]
+		if !ok {
+			source = "unset"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", f.key, value, source)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,211 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type AuditOutcome string
+
+const (
+	AuditOutcomeSuccess AuditOutcome = "success"
+	AuditOutcomeFailure AuditOutcome = "failure"
+	// AuditOutcomeDenied is for requests refused because the actor wasn't
+	// allowed to do what they asked.
+	AuditOutcomeDenied AuditOutcome = "denied"
+)
+
+type AuditEvent struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CreateAuditEventParams
+}
+
+type CreateAuditEventParams struct {
+	Action  string       `json:"action"`
+	Outcome AuditOutcome `json:"outcome"`
+	// ActorID is the user who made the request, if known. When an admin is
+	// impersonating the user, ImpersonatorID is the admin.
+	ActorID        *uuid.UUID `json:"actor_id"`
+	ImpersonatorID *uuid.UUID `json:"impersonator_id"`
+	TargetType     string     `json:"target_type"`
+	TargetID       string     `json:"target_id"`
+	IP             string     `json:"ip"`
+	UserAgent      string     `json:"user_agent"`
+	Detail         string     `json:"detail"`
+}
+
+// AuditEventFilter narrows down audit events. Zero fields don't filter.
+type AuditEventFilter struct {
+	Action   string
+	Outcome  AuditOutcome
+	ActorID  uuid.UUID
+	TargetID string
+	Since    time.Time
+	Until    time.Time
+	// BeforeID pages backwards through the log: only events older than this
+	// one are returned.
+	BeforeID int64
+	Limit    int
+}
+
+func (c Client) CreateAuditEvent(params CreateAuditEventParams) error {
+	c, span := c.startSpan("CreateAuditEvent")
+	defer span.End()
+
+	query := `
+		INSERT INTO audit_events (
+			created_at,
+			action,
+			outcome,
+			actor_id,
+			impersonator_id,
+			target_type,
+			target_id,
+			ip,
+			user_agent,
+			detail
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.ExecContext(
+		c.ctx,
+		query,
+		time.Now().UTC(),
+		params.Action,
+		string(params.Outcome),
+		nullableUUID(params.ActorID),
+		nullableUUID(params.ImpersonatorID),
+		params.TargetType,
+		params.TargetID,
+		params.IP,
+		params.UserAgent,
+		params.Detail,
+	)
+	return err
+}
+
+// GetAuditEvents returns the events matching the filter, newest first.
+func (c Client) GetAuditEvents(filter AuditEventFilter) ([]AuditEvent, error) {
+	c, span := c.startSpan("GetAuditEvents")
+	defer span.End()
+
+	events := []AuditEvent{}
+	err := c.EachAuditEvent(filter, func(event AuditEvent) error {
+		events = append(events, event)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// EachAuditEvent calls fn for each event matching the filter, newest first,
+// without loading them all into memory. It stops at the first error fn
+// returns.
+func (c Client) EachAuditEvent(filter AuditEventFilter, fn func(AuditEvent) error) error {
+	c, span := c.startSpan("EachAuditEvent")
+	defer span.End()
+
+	conditions := []string{}
+	args := []any{}
+	if filter.Action != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, filter.Action)
+	}
+	if filter.Outcome != "" {
+		conditions = append(conditions, "outcome = ?")
+		args = append(args, string(filter.Outcome))
+	}
+	if filter.ActorID != uuid.Nil {
+		conditions = append(conditions, "(actor_id = ? OR impersonator_id = ?)")
+		args = append(args, filter.ActorID.String(), filter.ActorID.String())
+	}
+	if filter.TargetID != "" {
+		conditions = append(conditions, "target_id = ?")
+		args = append(args, filter.TargetID)
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, filter.Since.UTC())
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, filter.Until.UTC())
+	}
+	if filter.BeforeID > 0 {
+		conditions = append(conditions, "id < ?")
+		args = append(args, filter.BeforeID)
+	}
+
+	query := `
+		SELECT id, created_at, action, outcome, actor_id, impersonator_id, target_type, target_id, ip, user_agent, detail
+		FROM audit_events
+	`
+	if len(conditions) > 0 {
+		query += "WHERE " + strings.Join(conditions, " AND ") + "\n"
+	}
+	query += "ORDER BY id DESC\n"
+	if filter.Limit > 0 {
+		query += "LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := c.db.QueryContext(c.ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event AuditEvent
+		var actorID, impersonatorID sql.NullString
+		if err := rows.Scan(
+			&event.ID,
+			&event.CreatedAt,
+			&event.Action,
+			&event.Outcome,
+			&actorID,
+			&impersonatorID,
+			&event.TargetType,
+			&event.TargetID,
+			&event.IP,
+			&event.UserAgent,
+			&event.Detail,
+		); err != nil {
+			return err
+		}
+		if event.ActorID, err = parseNullableUUID(actorID); err != nil {
+			return err
+		}
+		if event.ImpersonatorID, err = parseNullableUUID(impersonatorID); err != nil {
+			return err
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func nullableUUID(id *uuid.UUID) any {
+	if id == nil {
+		return nil
+	}
+	return id.String()
+}
+
+func parseNullableUUID(s sql.NullString) (*uuid.UUID, error) {
+	if !s.Valid {
+		return nil, nil
+	}
+	id, err := uuid.Parse(s.String)
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
@@ -1,23 +1,34 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
 type Client struct {
-	db *sql.DB
+	db  *sql.DB
+	ctx context.Context
 }
 
-func NewClient(pathToDB string) (Client, error) {
-	db, err := sql.Open("sqlite3", pathToDB)
-	if err != nil {
-		return Client{}, err
+// NewClient opens the database and migrates it to the current schema. If
+// observer isn't nil it's told about every statement run.
+func NewClient(pathToDB string, observer QueryObserver) (Client, error) {
+	var db *sql.DB
+	if observer != nil {
+		db = sql.OpenDB(&observedConnector{dsn: pathToDB, observe: observer})
+	} else {
+		var err error
+		db, err = sql.Open("sqlite3", pathToDB)
+		if err != nil {
+			return Client{}, err
+		}
 	}
-	c := Client{db}
-	err = c.autoMigrate()
+	c := Client{db: db}
+	err := c.autoMigrate()
 	if err != nil {
 		return Client{}, err
 	}
@@ -25,6 +36,19 @@ func NewClient(pathToDB string) (Client, error) {
 
 }
 
+func (c Client) Close() error {
+	return c.db.Close()
+}
+
+// Ping checks that the database can be opened and queried.
+func (c Client) Ping() error {
+	c, span := c.startSpan("Ping")
+	defer span.End()
+
+	var one int
+	return c.db.QueryRowContext(c.ctx, "SELECT 1").Scan(&one)
+}
+
 func (c *Client) autoMigrate() error {
 	userTable := `
 	CREATE TABLE IF NOT EXISTS users (
@@ -39,6 +63,46 @@ func (c *Client) autoMigrate() error {
 	if err != nil {
 		return err
 	}
+	err = c.addColumnIfNotExists("users", "verified_at", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("users", "failed_login_count", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("users", "locked_until", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("users", "totp_secret", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("users", "totp_enabled_at", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("users", "totp_last_step", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("users", "role", "TEXT NOT NULL DEFAULT 'user'")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("users", "disabled_at", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("users", "quota_storage_bytes", "INTEGER")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("users", "quota_video_count", "INTEGER")
+	if err != nil {
+		return err
+	}
 	refreshTokenTable := `
 	CREATE TABLE IF NOT EXISTS refresh_tokens (
 		token TEXT PRIMARY KEY,
@@ -54,6 +118,30 @@ func (c *Client) autoMigrate() error {
 	if err != nil {
 		return err
 	}
+	err = c.addColumnIfNotExists("refresh_tokens", "id", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("refresh_tokens", "user_agent", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("refresh_tokens", "ip", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("refresh_tokens", "last_used_at", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+	err = c.backfillRefreshTokenIDs()
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS refresh_tokens_id ON refresh_tokens(id)")
+	if err != nil {
+		return err
+	}
 
 	videoTable := `
 	CREATE TABLE IF NOT EXISTS videos (
@@ -72,18 +160,253 @@ func (c *Client) autoMigrate() error {
 	if err != nil {
 		return err
 	}
+	err = c.addColumnIfNotExists("videos", "version", "INTEGER NOT NULL DEFAULT 1")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "deleted_at", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "video_size", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "thumbnail_size", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec("CREATE INDEX IF NOT EXISTS videos_user_id ON videos(user_id)")
+	if err != nil {
+		return err
+	}
+
+	playlistTable := `
+	CREATE TABLE IF NOT EXISTS playlists (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		name TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		visibility TEXT NOT NULL DEFAULT 'private',
+		user_id TEXT NOT NULL,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(playlistTable)
+	if err != nil {
+		return err
+	}
+
+	playlistVideoTable := `
+	CREATE TABLE IF NOT EXISTS playlist_videos (
+		playlist_id TEXT NOT NULL,
+		video_id TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		PRIMARY KEY(playlist_id, video_id),
+		FOREIGN KEY(playlist_id) REFERENCES playlists(id),
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = c.db.Exec(playlistVideoTable)
+	if err != nil {
+		return err
+	}
+
+	passwordResetTokenTable := `
+	CREATE TABLE IF NOT EXISTS password_reset_tokens (
+		token_hash TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		used_at TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(passwordResetTokenTable)
+	if err != nil {
+		return err
+	}
+
+	emailVerificationTokenTable := `
+	CREATE TABLE IF NOT EXISTS email_verification_tokens (
+		token_hash TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		used_at TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(emailVerificationTokenTable)
+	if err != nil {
+		return err
+	}
+
+	totpRecoveryCodeTable := `
+	CREATE TABLE IF NOT EXISTS totp_recovery_codes (
+		code_hash TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		used_at TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(totpRecoveryCodeTable)
+	if err != nil {
+		return err
+	}
+
+	userIdentityTable := `
+	CREATE TABLE IF NOT EXISTS user_identities (
+		issuer TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		PRIMARY KEY(issuer, subject),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(userIdentityTable)
+	if err != nil {
+		return err
+	}
+
+	auditEventTable := `
+	CREATE TABLE IF NOT EXISTS audit_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at TIMESTAMP NOT NULL,
+		action TEXT NOT NULL,
+		outcome TEXT NOT NULL,
+		actor_id TEXT,
+		impersonator_id TEXT,
+		target_type TEXT NOT NULL DEFAULT '',
+		target_id TEXT NOT NULL DEFAULT '',
+		ip TEXT NOT NULL DEFAULT '',
+		user_agent TEXT NOT NULL DEFAULT '',
+		detail TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS audit_events_actor_id ON audit_events(actor_id);
+	CREATE INDEX IF NOT EXISTS audit_events_target_id ON audit_events(target_id);
+	CREATE TRIGGER IF NOT EXISTS audit_events_no_update
+	BEFORE UPDATE ON audit_events
+	BEGIN
+		SELECT RAISE(ABORT, 'audit_events is append-only');
+	END;
+	`
+	_, err = c.db.Exec(auditEventTable + auditEventNoDeleteTrigger)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// addColumnIfNotExists adds a column to a table created by an earlier
+// version of autoMigrate. SQLite has no ADD COLUMN IF NOT EXISTS.
+func (c *Client) addColumnIfNotExists(table, column, definition string) error {
+	rows, err := c.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    bool
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &primaryKey); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = c.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
 	return nil
 }
 
+// auditEventNoDeleteTrigger keeps audit events from being deleted. Reset
+// drops it to clear the table and then puts it back.
+const auditEventNoDeleteTrigger = `
+	CREATE TRIGGER IF NOT EXISTS audit_events_no_delete
+	BEFORE DELETE ON audit_events
+	BEGIN
+		SELECT RAISE(ABORT, 'audit_events is append-only');
+	END;
+`
+
 func (c Client) Reset() error {
-	if _, err := c.db.Exec("DELETE FROM refresh_tokens"); err != nil {
+	c, span := c.startSpan("Reset")
+	defer span.End()
+
+	if _, err := c.db.ExecContext(c.ctx, "DROP TRIGGER IF EXISTS audit_events_no_delete; DELETE FROM audit_events;"+auditEventNoDeleteTrigger); err != nil {
+		return fmt.Errorf("failed to reset table audit_events: %w", err)
+	}
+	if _, err := c.db.ExecContext(c.ctx, "DELETE FROM playlist_videos"); err != nil {
+		return fmt.Errorf("failed to reset table playlist_videos: %w", err)
+	}
+	if _, err := c.db.ExecContext(c.ctx, "DELETE FROM playlists"); err != nil {
+		return fmt.Errorf("failed to reset table playlists: %w", err)
+	}
+	if _, err := c.db.ExecContext(c.ctx, "DELETE FROM user_identities"); err != nil {
+		return fmt.Errorf("failed to reset table user_identities: %w", err)
+	}
+	if _, err := c.db.ExecContext(c.ctx, "DELETE FROM totp_recovery_codes"); err != nil {
+		return fmt.Errorf("failed to reset table totp_recovery_codes: %w", err)
+	}
+	if _, err := c.db.ExecContext(c.ctx, "DELETE FROM email_verification_tokens"); err != nil {
+		return fmt.Errorf("failed to reset table email_verification_tokens: %w", err)
+	}
+	if _, err := c.db.ExecContext(c.ctx, "DELETE FROM password_reset_tokens"); err != nil {
+		return fmt.Errorf("failed to reset table password_reset_tokens: %w", err)
+	}
+	if _, err := c.db.ExecContext(c.ctx, "DELETE FROM refresh_tokens"); err != nil {
 		return fmt.Errorf("failed to reset table refresh_tokens: %w", err)
 	}
-	if _, err := c.db.Exec("DELETE FROM users"); err != nil {
+	if _, err := c.db.ExecContext(c.ctx, "DELETE FROM users"); err != nil {
 		return fmt.Errorf("failed to reset table users: %w", err)
 	}
-	if _, err := c.db.Exec("DELETE FROM videos"); err != nil {
+	if _, err := c.db.ExecContext(c.ctx, "DELETE FROM videos"); err != nil {
 		return fmt.Errorf("failed to reset table videos: %w", err)
 	}
 	return nil
 }
+
+// DeleteExpiredTokens deletes refresh, password reset and email verification
+// tokens that expired before now, returning how many were deleted.
+func (c Client) DeleteExpiredTokens(now time.Time) (int64, error) {
+	c, span := c.startSpan("DeleteExpiredTokens")
+	defer span.End()
+
+	tx, err := c.db.BeginTx(c.ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var deleted int64
+	for _, table := range []string{"refresh_tokens", "password_reset_tokens", "email_verification_tokens"} {
+		result, err := tx.ExecContext(c.ctx, "DELETE FROM "+table+" WHERE expires_at < ?", now.UTC())
+		if err != nil {
+			return 0, fmt.Errorf("failed to delete expired %s: %w", table, err)
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		deleted += n
+	}
+	return deleted, tx.Commit()
+}
@@ -0,0 +1,85 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrInvalidVerificationToken = errors.New("invalid or expired email verification token")
+
+type CreateEmailVerificationTokenParams struct {
+	TokenHash string
+	UserID    uuid.UUID
+	ExpiresAt time.Time
+}
+
+func (c Client) CreateEmailVerificationToken(params CreateEmailVerificationTokenParams) error {
+	c, span := c.startSpan("CreateEmailVerificationToken")
+	defer span.End()
+
+	query := `
+		INSERT INTO email_verification_tokens (
+			token_hash,
+			created_at,
+			user_id,
+			expires_at
+		) VALUES (?, CURRENT_TIMESTAMP, ?, ?)
+	`
+	_, err := c.db.ExecContext(c.ctx, query, params.TokenHash, params.UserID.String(), params.ExpiresAt.UTC())
+	return err
+}
+
+// VerifyEmail consumes a verification token and marks its user's email as
+// verified. Other outstanding verification tokens for the user are used up
+// at the same time.
+func (c Client) VerifyEmail(tokenHash string) (uuid.UUID, error) {
+	c, span := c.startSpan("VerifyEmail")
+	defer span.End()
+
+	tx, err := c.db.BeginTx(c.ctx, nil)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT user_id
+		FROM email_verification_tokens
+		WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?
+	`
+	var userIDString string
+	err = tx.QueryRowContext(c.ctx, query, tokenHash, time.Now().UTC()).Scan(&userIDString)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return uuid.Nil, ErrInvalidVerificationToken
+		}
+		return uuid.Nil, err
+	}
+	userID, err := uuid.Parse(userIDString)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	if _, err := tx.ExecContext(
+		c.ctx,
+		"UPDATE email_verification_tokens SET used_at = CURRENT_TIMESTAMP WHERE user_id = ? AND used_at IS NULL",
+		userIDString,
+	); err != nil {
+		return uuid.Nil, err
+	}
+	if _, err := tx.ExecContext(
+		c.ctx,
+		"UPDATE users SET verified_at = COALESCE(verified_at, CURRENT_TIMESTAMP), updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+		userIDString,
+	); err != nil {
+		return uuid.Nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return uuid.Nil, err
+	}
+	return userID, nil
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// QueryObserver is told how long each statement took to run, and whether it
+// failed. op is "exec" for statements that don't return rows and "query" for
+// ones that do; a query's time doesn't include reading its rows.
+type QueryObserver func(op string, duration time.Duration, err error)
+
+// observedConnector opens SQLite connections that report every statement to
+// an observer.
+type observedConnector struct {
+	dsn     string
+	observe QueryObserver
+}
+
+func (c *observedConnector) Connect(context.Context) (driver.Conn, error) {
+	conn, err := c.Driver().Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &observedConn{Conn: conn, observe: c.observe}, nil
+}
+
+func (c *observedConnector) Driver() driver.Driver {
+	return &sqlite3.SQLiteDriver{}
+}
+
+type observedConn struct {
+	driver.Conn
+	observe QueryObserver
+}
+
+func (c *observedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	result, err := execer.ExecContext(ctx, query, args)
+	if !errors.Is(err, driver.ErrSkip) {
+		c.observe("exec", time.Since(start), err)
+		recordSpanError(ctx, err)
+	}
+	return result, err
+}
+
+func (c *observedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	rows, err := queryer.QueryContext(ctx, query, args)
+	if !errors.Is(err, driver.ErrSkip) {
+		c.observe("query", time.Since(start), err)
+		recordSpanError(ctx, err)
+	}
+	return rows, err
+}
+
+func (c *observedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return preparer.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *observedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
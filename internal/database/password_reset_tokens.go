@@ -0,0 +1,94 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+
+type CreatePasswordResetTokenParams struct {
+	TokenHash string
+	UserID    uuid.UUID
+	ExpiresAt time.Time
+}
+
+// CreatePasswordResetToken stores the hash of a reset token. Only the hash is
+// kept so a leaked database can't be used to reset passwords.
+func (c Client) CreatePasswordResetToken(params CreatePasswordResetTokenParams) error {
+	c, span := c.startSpan("CreatePasswordResetToken")
+	defer span.End()
+
+	query := `
+		INSERT INTO password_reset_tokens (
+			token_hash,
+			created_at,
+			user_id,
+			expires_at
+		) VALUES (?, CURRENT_TIMESTAMP, ?, ?)
+	`
+	_, err := c.db.ExecContext(c.ctx, query, params.TokenHash, params.UserID.String(), params.ExpiresAt.UTC())
+	return err
+}
+
+// ResetPassword consumes a reset token and sets the owning user's password.
+// Every other outstanding reset token and every refresh token for the user
+// is invalidated in the same transaction.
+func (c Client) ResetPassword(tokenHash, hashedPassword string) (uuid.UUID, error) {
+	c, span := c.startSpan("ResetPassword")
+	defer span.End()
+
+	tx, err := c.db.BeginTx(c.ctx, nil)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT user_id
+		FROM password_reset_tokens
+		WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?
+	`
+	var userIDString string
+	err = tx.QueryRowContext(c.ctx, query, tokenHash, time.Now().UTC()).Scan(&userIDString)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return uuid.Nil, ErrInvalidResetToken
+		}
+		return uuid.Nil, err
+	}
+	userID, err := uuid.Parse(userIDString)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	if _, err := tx.ExecContext(
+		c.ctx,
+		"UPDATE password_reset_tokens SET used_at = CURRENT_TIMESTAMP WHERE user_id = ? AND used_at IS NULL",
+		userIDString,
+	); err != nil {
+		return uuid.Nil, err
+	}
+	if _, err := tx.ExecContext(
+		c.ctx,
+		"UPDATE users SET password = ?, failed_login_count = 0, locked_until = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+		hashedPassword, userIDString,
+	); err != nil {
+		return uuid.Nil, err
+	}
+	if _, err := tx.ExecContext(
+		c.ctx,
+		"UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE user_id = ? AND revoked_at IS NULL",
+		userIDString,
+	); err != nil {
+		return uuid.Nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return uuid.Nil, err
+	}
+	return userID, nil
+}
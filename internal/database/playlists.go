@@ -0,0 +1,396 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type PlaylistVisibility string
+
+const (
+	PlaylistVisibilityPrivate PlaylistVisibility = "private"
+	PlaylistVisibilityPublic  PlaylistVisibility = "public"
+)
+
+func (v PlaylistVisibility) IsValid() bool {
+	switch v {
+	case PlaylistVisibilityPrivate, PlaylistVisibilityPublic:
+		return true
+	}
+	return false
+}
+
+var ErrInvalidPlaylistOrder = errors.New("invalid playlist order")
+
+type Playlist struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	CreatePlaylistParams
+}
+
+type CreatePlaylistParams struct {
+	Name        string             `json:"name"`
+	Description string             `json:"description"`
+	Visibility  PlaylistVisibility `json:"visibility"`
+	UserID      uuid.UUID          `json:"user_id"`
+}
+
+func (c Client) GetPlaylists(userID uuid.UUID) ([]Playlist, error) {
+	c, span := c.startSpan("GetPlaylists")
+	defer span.End()
+
+	query := `
+	SELECT
+		id,
+		created_at,
+		updated_at,
+		name,
+		description,
+		visibility,
+		user_id
+	FROM playlists
+	WHERE user_id = ?
+	ORDER BY created_at DESC
+	`
+
+	rows, err := c.db.QueryContext(c.ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	playlists := []Playlist{}
+	for rows.Next() {
+		var playlist Playlist
+		if err := rows.Scan(
+			&playlist.ID,
+			&playlist.CreatedAt,
+			&playlist.UpdatedAt,
+			&playlist.Name,
+			&playlist.Description,
+			&playlist.Visibility,
+			&playlist.UserID,
+		); err != nil {
+			return nil, err
+		}
+		playlists = append(playlists, playlist)
+	}
+
+	return playlists, nil
+}
+
+func (c Client) CreatePlaylist(params CreatePlaylistParams) (Playlist, error) {
+	c, span := c.startSpan("CreatePlaylist")
+	defer span.End()
+
+	id := uuid.New()
+	query := `
+	INSERT INTO playlists (
+		id,
+		created_at,
+		updated_at,
+		name,
+		description,
+		visibility,
+		user_id
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	`
+	_, err := c.db.ExecContext(c.ctx, query, id, params.Name, params.Description, params.Visibility, params.UserID)
+	if err != nil {
+		return Playlist{}, err
+	}
+
+	return c.GetPlaylist(id)
+}
+
+func (c Client) GetPlaylist(id uuid.UUID) (Playlist, error) {
+	c, span := c.startSpan("GetPlaylist")
+	defer span.End()
+
+	query := `
+	SELECT
+		id,
+		created_at,
+		updated_at,
+		name,
+		description,
+		visibility,
+		user_id
+	FROM playlists
+	WHERE id = ?
+	`
+
+	var playlist Playlist
+	err := c.db.QueryRowContext(c.ctx, query, id).Scan(
+		&playlist.ID,
+		&playlist.CreatedAt,
+		&playlist.UpdatedAt,
+		&playlist.Name,
+		&playlist.Description,
+		&playlist.Visibility,
+		&playlist.UserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Playlist{}, nil
+		}
+		return Playlist{}, err
+	}
+
+	return playlist, nil
+}
+
+func (c Client) UpdatePlaylist(playlist Playlist) error {
+	c, span := c.startSpan("UpdatePlaylist")
+	defer span.End()
+
+	query := `
+	UPDATE playlists
+	SET
+		updated_at = CURRENT_TIMESTAMP,
+		name = ?,
+		description = ?,
+		visibility = ?
+	WHERE id = ?
+	`
+
+	_, err := c.db.ExecContext(
+		c.ctx,
+		query,
+		playlist.Name,
+		playlist.Description,
+		playlist.Visibility,
+		playlist.ID,
+	)
+	return err
+}
+
+func (c Client) DeletePlaylist(id uuid.UUID) error {
+	c, span := c.startSpan("DeletePlaylist")
+	defer span.End()
+
+	tx, err := c.db.BeginTx(c.ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(c.ctx, "DELETE FROM playlist_videos WHERE playlist_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(c.ctx, "DELETE FROM playlists WHERE id = ?", id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetPlaylistVideos returns the videos in a playlist in playlist order.
+// Trashed videos keep their place but are left out until restored.
+func (c Client) GetPlaylistVideos(playlistID uuid.UUID) ([]Video, error) {
+	c, span := c.startSpan("GetPlaylistVideos")
+	defer span.End()
+
+	query := `
+	SELECT
+		v.id,
+		v.created_at,
+		v.updated_at,
+		v.title,
+		v.description,
+		v.thumbnail_url,
+		v.video_url,
+		v.user_id,
+		v.version,
+		v.deleted_at,
+		v.video_size,
+		v.thumbnail_size
+	FROM playlist_videos pv
+	JOIN videos v ON v.id = pv.video_id
+	WHERE pv.playlist_id = ? AND v.deleted_at IS NULL
+	ORDER BY pv.position ASC
+	`
+	return c.queryVideos(query, playlistID)
+}
+
+// AddPlaylistVideo appends a video to the end of a playlist. Adding a video
+// that is already in the playlist is a no-op.
+func (c Client) AddPlaylistVideo(playlistID, videoID uuid.UUID) error {
+	c, span := c.startSpan("AddPlaylistVideo")
+	defer span.End()
+
+	tx, err := c.db.BeginTx(c.ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT OR IGNORE INTO playlist_videos (
+		playlist_id,
+		video_id,
+		position
+	) VALUES (
+		?,
+		?,
+		(SELECT COALESCE(MAX(position) + 1, 0) FROM playlist_videos WHERE playlist_id = ?)
+	)
+	`
+	if _, err := tx.ExecContext(c.ctx, query, playlistID, videoID, playlistID); err != nil {
+		return err
+	}
+	if err := touchPlaylist(tx, playlistID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RemovePlaylistVideo removes a video from a playlist and closes the gap it
+// leaves so positions stay contiguous.
+func (c Client) RemovePlaylistVideo(playlistID, videoID uuid.UUID) error {
+	c, span := c.startSpan("RemovePlaylistVideo")
+	defer span.End()
+
+	tx, err := c.db.BeginTx(c.ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var position int
+	err = tx.QueryRowContext(c.ctx,
+		"SELECT position FROM playlist_videos WHERE playlist_id = ? AND video_id = ?",
+		playlistID, videoID,
+	).Scan(&position)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	if _, err := tx.ExecContext(
+		c.ctx,
+		"DELETE FROM playlist_videos WHERE playlist_id = ? AND video_id = ?",
+		playlistID, videoID,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(
+		c.ctx,
+		"UPDATE playlist_videos SET position = position - 1 WHERE playlist_id = ? AND position > ?",
+		playlistID, position,
+	); err != nil {
+		return err
+	}
+	if err := touchPlaylist(tx, playlistID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ReorderPlaylistVideos sets the order of a playlist. videoIDs must contain
+// exactly the videos GetPlaylistVideos returns; trashed videos are moved to
+// the end in their existing order.
+func (c Client) ReorderPlaylistVideos(playlistID uuid.UUID, videoIDs []uuid.UUID) error {
+	c, span := c.startSpan("ReorderPlaylistVideos")
+	defer span.End()
+
+	tx, err := c.db.BeginTx(c.ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	SELECT pv.video_id, v.deleted_at IS NOT NULL
+	FROM playlist_videos pv
+	JOIN videos v ON v.id = pv.video_id
+	WHERE pv.playlist_id = ?
+	ORDER BY pv.position ASC
+	`
+	rows, err := tx.QueryContext(c.ctx, query, playlistID)
+	if err != nil {
+		return err
+	}
+	visible := map[uuid.UUID]struct{}{}
+	trashed := []uuid.UUID{}
+	for rows.Next() {
+		var videoID uuid.UUID
+		var isTrashed bool
+		if err := rows.Scan(&videoID, &isTrashed); err != nil {
+			rows.Close()
+			return err
+		}
+		if isTrashed {
+			trashed = append(trashed, videoID)
+		} else {
+			visible[videoID] = struct{}{}
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(videoIDs) != len(visible) {
+		return fmt.Errorf("%w: expected %d video IDs, got %d", ErrInvalidPlaylistOrder, len(visible), len(videoIDs))
+	}
+	seen := make(map[uuid.UUID]struct{}, len(videoIDs))
+	for _, videoID := range videoIDs {
+		if _, ok := visible[videoID]; !ok {
+			return fmt.Errorf("%w: video %s is not in playlist", ErrInvalidPlaylistOrder, videoID)
+		}
+		if _, ok := seen[videoID]; ok {
+			return fmt.Errorf("%w: duplicate video ID %s", ErrInvalidPlaylistOrder, videoID)
+		}
+		seen[videoID] = struct{}{}
+	}
+
+	for position, videoID := range append(videoIDs, trashed...) {
+		_, err := tx.ExecContext(
+			c.ctx,
+			"UPDATE playlist_videos SET position = ? WHERE playlist_id = ? AND video_id = ?",
+			position, playlistID, videoID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	if err := touchPlaylist(tx, playlistID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func touchPlaylist(tx *sql.Tx, playlistID uuid.UUID) error {
+	_, err := tx.Exec("UPDATE playlists SET updated_at = CURRENT_TIMESTAMP WHERE id = ?", playlistID)
+	return err
+}
+
+// removeVideoFromPlaylists drops a video from every playlist it appears in,
+// closing the gaps it leaves behind.
+func removeVideoFromPlaylists(tx *sql.Tx, videoID uuid.UUID) error {
+	query := `
+	UPDATE playlist_videos
+	SET position = position - 1
+	WHERE EXISTS (
+		SELECT 1 FROM playlist_videos removed
+		WHERE removed.video_id = ?
+			AND removed.playlist_id = playlist_videos.playlist_id
+			AND removed.position < playlist_videos.position
+	)
+	`
+	if _, err := tx.Exec(query, videoID); err != nil {
+		return err
+	}
+	_, err := tx.Exec("DELETE FROM playlist_videos WHERE video_id = ?", videoID)
+	return err
+}
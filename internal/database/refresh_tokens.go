@@ -9,28 +9,49 @@ import (
 
 type RefreshToken struct {
 	CreateRefreshTokenParams
-	CreatedAt time.Time  `json:"created_at"`
-	UpdatedAt time.Time  `json:"updated_at"`
-	RevokedAt *time.Time `json:"revoked_at"`
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
 }
 
 type CreateRefreshTokenParams struct {
 	Token     string    `json:"token"`
 	UserID    uuid.UUID `json:"user_id"`
 	ExpiresAt time.Time `json:"expires_at"`
+	UserAgent string    `json:"user_agent"`
+	IP        string    `json:"ip"`
+}
+
+// Session is an active refresh token as shown to its owner. It never
+// includes the token itself.
+type Session struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
 }
 
 func (c Client) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToken, error) {
+	c, span := c.startSpan("CreateRefreshToken")
+	defer span.End()
+
 	query := `
 		INSERT INTO refresh_tokens (
 			token,
+			id,
 			created_at,
 			updated_at,
 			user_id,
-			expires_at
-		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
+			expires_at,
+			user_agent,
+			ip
+		) VALUES (?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, params.Token, params.UserID.String(), params.ExpiresAt)
+	_, err := c.db.ExecContext(c.ctx, query, params.Token, uuid.New().String(), params.UserID.String(), params.ExpiresAt, params.UserAgent, params.IP)
 	if err != nil {
 		return RefreshToken{}, err
 	}
@@ -39,25 +60,60 @@ func (c Client) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToke
 }
 
 func (c Client) RevokeRefreshToken(token string) error {
+	c, span := c.startSpan("RevokeRefreshToken")
+	defer span.End()
+
 	query := `
 		UPDATE refresh_tokens
 		SET revoked_at = CURRENT_TIMESTAMP
 		WHERE token = ?
 	`
-	_, err := c.db.Exec(query, token)
+	_, err := c.db.ExecContext(c.ctx, query, token)
+	return err
+}
+
+// RevokeUserRefreshTokens revokes every active refresh token belonging to
+// the user.
+func (c Client) RevokeUserRefreshTokens(userID uuid.UUID) error {
+	c, span := c.startSpan("RevokeUserRefreshTokens")
+	defer span.End()
+
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND revoked_at IS NULL
+	`
+	_, err := c.db.ExecContext(c.ctx, query, userID.String())
+	return err
+}
+
+// TouchRefreshToken records that the token was just used, and from where.
+func (c Client) TouchRefreshToken(token, userAgent, ip string) error {
+	c, span := c.startSpan("TouchRefreshToken")
+	defer span.End()
+
+	query := `
+		UPDATE refresh_tokens
+		SET last_used_at = ?, user_agent = ?, ip = ?
+		WHERE token = ?
+	`
+	_, err := c.db.ExecContext(c.ctx, query, time.Now().UTC(), userAgent, ip, token)
 	return err
 }
 
 func (c Client) GetRefreshToken(token string) (RefreshToken, error) {
+	c, span := c.startSpan("GetRefreshToken")
+	defer span.End()
+
 	query := `
-		SELECT token, created_at, updated_at, user_id, expires_at, revoked_at
+		SELECT token, id, created_at, updated_at, user_id, expires_at, revoked_at, user_agent, ip, last_used_at
 		FROM refresh_tokens
 		WHERE token = ?
 	`
 	var rt RefreshToken
-	var userID string
-	err := c.db.QueryRow(query, token).
-		Scan(&rt.Token, &rt.CreatedAt, &rt.UpdatedAt, &userID, &rt.ExpiresAt, &rt.RevokedAt)
+	var id, userID string
+	err := c.db.QueryRowContext(c.ctx, query, token).
+		Scan(&rt.Token, &id, &rt.CreatedAt, &rt.UpdatedAt, &userID, &rt.ExpiresAt, &rt.RevokedAt, &rt.UserAgent, &rt.IP, &rt.LastUsedAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return RefreshToken{}, nil
@@ -65,6 +121,10 @@ func (c Client) GetRefreshToken(token string) (RefreshToken, error) {
 		return RefreshToken{}, err
 	}
 
+	rt.ID, err = uuid.Parse(id)
+	if err != nil {
+		return RefreshToken{}, err
+	}
 	rt.UserID, err = uuid.Parse(userID)
 	if err != nil {
 		return RefreshToken{}, err
@@ -73,11 +133,104 @@ func (c Client) GetRefreshToken(token string) (RefreshToken, error) {
 	return rt, nil
 }
 
+// GetUserSessions returns the user's unrevoked, unexpired refresh tokens,
+// most recently used first.
+func (c Client) GetUserSessions(userID uuid.UUID) ([]Session, error) {
+	c, span := c.startSpan("GetUserSessions")
+	defer span.End()
+
+	query := `
+		SELECT id, created_at, last_used_at, expires_at, user_agent, ip
+		FROM refresh_tokens
+		WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ?
+		ORDER BY COALESCE(last_used_at, created_at) DESC
+	`
+	rows, err := c.db.QueryContext(c.ctx, query, userID.String(), time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		var id string
+		if err := rows.Scan(&id, &session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt, &session.UserAgent, &session.IP); err != nil {
+			return nil, err
+		}
+		session.ID, err = uuid.Parse(id)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// RevokeUserSession revokes one of the user's active sessions. It reports
+// false if the user has no active session with that ID.
+func (c Client) RevokeUserSession(userID, sessionID uuid.UUID) (bool, error) {
+	c, span := c.startSpan("RevokeUserSession")
+	defer span.End()
+
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND user_id = ? AND revoked_at IS NULL AND expires_at > ?
+	`
+	result, err := c.db.ExecContext(c.ctx, query, sessionID.String(), userID.String(), time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
 func (c Client) DeleteRefreshToken(token string) error {
+	c, span := c.startSpan("DeleteRefreshToken")
+	defer span.End()
+
 	query := `
 		DELETE FROM refresh_tokens
 		WHERE token = ?
 	`
-	_, err := c.db.Exec(query, token)
+	_, err := c.db.ExecContext(c.ctx, query, token)
 	return err
 }
+
+// backfillRefreshTokenIDs gives refresh tokens created before sessions had
+// IDs one of their own.
+func (c *Client) backfillRefreshTokenIDs() error {
+	rows, err := c.db.Query("SELECT token FROM refresh_tokens WHERE id IS NULL")
+	if err != nil {
+		return err
+	}
+	tokens := []string{}
+	for rows.Next() {
+		var token string
+		if err := rows.Scan(&token); err != nil {
+			rows.Close()
+			return err
+		}
+		tokens = append(tokens, token)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, token := range tokens {
+		_, err := c.db.Exec("UPDATE refresh_tokens SET id = ? WHERE token = ?", uuid.New().String(), token)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package database
+
+import (
+	"github.com/google/uuid"
+)
+
+// SetPendingTOTPSecret stores a new TOTP secret for the user without turning
+// two-factor login on. EnableTOTP does that once the user proves their
+// authenticator has the secret.
+func (c Client) SetPendingTOTPSecret(userID uuid.UUID, secret string) error {
+	c, span := c.startSpan("SetPendingTOTPSecret")
+	defer span.End()
+
+	query := `
+		UPDATE users
+		SET totp_secret = ?, totp_enabled_at = NULL, totp_last_step = 0, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND totp_enabled_at IS NULL
+	`
+	_, err := c.db.ExecContext(c.ctx, query, secret, userID.String())
+	return err
+}
+
+// EnableTOTP turns on two-factor login, records the time step of the code
+// used to confirm it and replaces the user's recovery codes.
+func (c Client) EnableTOTP(userID uuid.UUID, step int64, recoveryCodeHashes []string) error {
+	c, span := c.startSpan("EnableTOTP")
+	defer span.End()
+
+	tx, err := c.db.BeginTx(c.ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE users
+		SET totp_enabled_at = CURRENT_TIMESTAMP, totp_last_step = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	if _, err := tx.ExecContext(c.ctx, query, step, userID.String()); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(c.ctx, "DELETE FROM totp_recovery_codes WHERE user_id = ?", userID.String()); err != nil {
+		return err
+	}
+	for _, codeHash := range recoveryCodeHashes {
+		_, err := tx.ExecContext(
+			c.ctx,
+			"INSERT INTO totp_recovery_codes (code_hash, created_at, user_id) VALUES (?, CURRENT_TIMESTAMP, ?)",
+			codeHash, userID.String(),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (c Client) DisableTOTP(userID uuid.UUID) error {
+	c, span := c.startSpan("DisableTOTP")
+	defer span.End()
+
+	tx, err := c.db.BeginTx(c.ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE users
+		SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	if _, err := tx.ExecContext(c.ctx, query, userID.String()); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(c.ctx, "DELETE FROM totp_recovery_codes WHERE user_id = ?", userID.String()); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseTOTPStep records that a code for the given time step was accepted. It
+// returns false if a code for this or a later step was already used, so a
+// code can't be replayed.
+func (c Client) UseTOTPStep(userID uuid.UUID, step int64) (bool, error) {
+	c, span := c.startSpan("UseTOTPStep")
+	defer span.End()
+
+	query := `
+		UPDATE users
+		SET totp_last_step = ?
+		WHERE id = ? AND totp_last_step < ?
+	`
+	result, err := c.db.ExecContext(c.ctx, query, step, userID.String(), step)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// UseRecoveryCode marks an unused recovery code as used. It returns false if
+// the code doesn't exist or was already used.
+func (c Client) UseRecoveryCode(userID uuid.UUID, codeHash string) (bool, error) {
+	c, span := c.startSpan("UseRecoveryCode")
+	defer span.End()
+
+	query := `
+		UPDATE totp_recovery_codes
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+	`
+	result, err := c.db.ExecContext(c.ctx, query, userID.String(), codeHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
@@ -0,0 +1,44 @@
+package database
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database")
+
+// WithContext returns a client whose methods run their queries under ctx, so
+// they're cancelled along with it and traced as part of it.
+func (c Client) WithContext(ctx context.Context) Client {
+	c.ctx = ctx
+	return c
+}
+
+// startSpan starts a span for a Client method and returns a client that runs
+// the method's queries, and any other methods it calls, under that span.
+func (c Client) startSpan(method string) (Client, trace.Span) {
+	ctx := c.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, span := tracer.Start(ctx, "database.Client."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", "sqlite")),
+	)
+	c.ctx = ctx
+	return c, span
+}
+
+// recordSpanError marks the span the statement ran under as failed.
+func recordSpanError(ctx context.Context, err error) {
+	if err == nil {
+		return
+	}
+	span := trace.SpanFromContext(ctx)
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,65 @@
+package database
+
+import (
+	"github.com/google/uuid"
+)
+
+// GetUserByIdentity returns the user linked to an external identity
+// provider's subject, or nil if the identity isn't linked to anyone.
+func (c Client) GetUserByIdentity(issuer, subject string) (*User, error) {
+	c, span := c.startSpan("GetUserByIdentity")
+	defer span.End()
+
+	query := `
+		SELECT u.id, u.created_at, u.updated_at, u.email, u.password, u.verified_at, u.failed_login_count, u.locked_until, u.totp_secret, u.totp_enabled_at, u.totp_last_step, u.role, u.disabled_at, u.quota_storage_bytes, u.quota_video_count
+		FROM users u
+		JOIN user_identities ui ON u.id = ui.user_id
+		WHERE ui.issuer = ? AND ui.subject = ?
+	`
+	return c.queryUser(query, issuer, subject)
+}
+
+// HasUserIdentity reports whether the user is linked to any external
+// identity provider.
+func (c Client) HasUserIdentity(userID uuid.UUID) (bool, error) {
+	c, span := c.startSpan("HasUserIdentity")
+	defer span.End()
+
+	query := `
+		SELECT EXISTS (SELECT 1 FROM user_identities WHERE user_id = ?)
+	`
+	var linked bool
+	err := c.db.QueryRowContext(c.ctx, query, userID.String()).Scan(&linked)
+	return linked, err
+}
+
+func (c Client) CreateUserIdentity(userID uuid.UUID, issuer, subject string) error {
+	c, span := c.startSpan("CreateUserIdentity")
+	defer span.End()
+
+	query := `
+		INSERT INTO user_identities (
+			issuer,
+			subject,
+			created_at,
+			user_id
+		) VALUES (?, ?, CURRENT_TIMESTAMP, ?)
+	`
+	_, err := c.db.ExecContext(c.ctx, query, issuer, subject, userID.String())
+	return err
+}
+
+// MarkEmailVerified records that the user's email address is verified, for
+// when something other than a verification token vouches for it.
+func (c Client) MarkEmailVerified(userID uuid.UUID) error {
+	c, span := c.startSpan("MarkEmailVerified")
+	defer span.End()
+
+	query := `
+		UPDATE users
+		SET verified_at = COALESCE(verified_at, CURRENT_TIMESTAMP), updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.ExecContext(c.ctx, query, userID.String())
+	return err
+}
@@ -3,102 +3,169 @@ package database
 import (
 	"database/sql"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/api"
 	"github.com/google/uuid"
 )
 
 type User struct {
-	ID        uuid.UUID `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID               uuid.UUID  `json:"id"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+	VerifiedAt       *time.Time `json:"verified_at"`
+	FailedLoginCount int        `json:"-"`
+	LockedUntil      *time.Time `json:"-"`
+	TOTPSecret       *string    `json:"-"`
+	TOTPEnabledAt    *time.Time `json:"totp_enabled_at"`
+	TOTPLastStep     int64      `json:"-"`
+	Role             Role       `json:"role"`
+	DisabledAt       *time.Time `json:"disabled_at"`
+	Quota            Quota      `json:"-"`
 	CreateUserParams
 }
 
+// Public returns what clients are shown of the user, which leaves out the
+// password hash and login secrets.
+func (u User) Public() api.User {
+	return api.User{
+		ID:            u.ID,
+		CreatedAt:     u.CreatedAt,
+		UpdatedAt:     u.UpdatedAt,
+		Email:         u.Email,
+		VerifiedAt:    u.VerifiedAt,
+		TOTPEnabledAt: u.TOTPEnabledAt,
+		Role:          string(u.Role),
+		DisabledAt:    u.DisabledAt,
+	}
+}
+
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+var roleRanks = map[Role]int{
+	RoleUser:      1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+}
+
+func (r Role) IsValid() bool {
+	_, ok := roleRanks[r]
+	return ok
+}
+
+// Includes reports whether r grants everything other does. Admins can do
+// anything moderators can, and moderators anything users can.
+func (r Role) Includes(other Role) bool {
+	return r.IsValid() && roleRanks[r] >= roleRanks[other]
+}
+
 type CreateUserParams struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email string `json:"email"`
+	// Password is the bcrypt hash, never the plain password, and is never
+	// sent to clients.
+	Password string `json:"-"`
 }
 
-func (c Client) GetUsers() ([]User, error) {
+type GetUsersParams struct {
+	// Search, if set, matches users whose email contains it.
+	Search string
+	Limit  int
+	Offset int
+}
+
+// GetUsers returns one page of users, oldest first, along with the total
+// number of users matching the search.
+func (c Client) GetUsers(params GetUsersParams) ([]User, int, error) {
+	c, span := c.startSpan("GetUsers")
+	defer span.End()
+
+	where := ""
+	args := []any{}
+	if params.Search != "" {
+		where = `WHERE email LIKE ? ESCAPE '\'`
+		args = append(args, "%"+escapeLike(params.Search)+"%")
+	}
+
+	var total int
+	err := c.db.QueryRowContext(c.ctx, "SELECT COUNT(*) FROM users "+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
 	query := `
-		SELECT
-			id,
-			email
+		SELECT id, created_at, updated_at, email, password, verified_at, failed_login_count, locked_until, totp_secret, totp_enabled_at, totp_last_step, role, disabled_at, quota_storage_bytes, quota_video_count
 		FROM users
+		` + where + `
+		ORDER BY created_at, id
+		LIMIT ? OFFSET ?
 	`
-
-	rows, err := c.db.Query(query)
+	rows, err := c.db.QueryContext(c.ctx, query, append(args, params.Limit, params.Offset)...)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer rows.Close()
 
 	users := []User{}
 	for rows.Next() {
-		var user User
-		var id string
-		if err := rows.Scan(&id, &user.Email); err != nil {
-			return nil, err
-		}
-		user.ID, err = uuid.Parse(id)
+		user, err := scanUser(rows)
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
-		users = append(users, user)
+		users = append(users, *user)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return users, total, nil
+}
 
-	return users, nil
+// escapeLike escapes the LIKE wildcards in s so it only matches literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
 }
 
 func (c Client) GetUserByEmail(email string) (User, error) {
+	c, span := c.startSpan("GetUserByEmail")
+	defer span.End()
+
 	query := `
-		SELECT id, created_at, updated_at, email, password
+		SELECT id, created_at, updated_at, email, password, verified_at, failed_login_count, locked_until, totp_secret, totp_enabled_at, totp_last_step, role, disabled_at, quota_storage_bytes, quota_video_count
 		FROM users
 		WHERE email = ?
 	`
-	var user User
-	var id string
-	err := c.db.QueryRow(query, email).Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return User{}, nil
-		}
-		return User{}, err
-	}
-	user.ID, err = uuid.Parse(id)
-	if err != nil {
+	user, err := c.queryUser(query, email)
+	if err != nil || user == nil {
 		return User{}, err
 	}
-	return user, nil
+	return *user, nil
 }
 
 func (c Client) GetUserByRefreshToken(token string) (*User, error) {
+	c, span := c.startSpan("GetUserByRefreshToken")
+	defer span.End()
+
 	query := `
-		SELECT u.id, u.email, u.created_at, u.updated_at, u.password
+		SELECT u.id, u.created_at, u.updated_at, u.email, u.password, u.verified_at, u.failed_login_count, u.locked_until, u.totp_secret, u.totp_enabled_at, u.totp_last_step, u.role, u.disabled_at, u.quota_storage_bytes, u.quota_video_count
 		FROM users u
 		JOIN refresh_tokens rt ON u.id = rt.user_id
-		WHERE rt.token = ?
+		WHERE rt.token = ? AND rt.revoked_at IS NULL AND rt.expires_at > ?
 	`
-
-	var user User
-	var id string
-	err := c.db.QueryRow(query, token).Scan(&id, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.Password)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, nil
-		}
-		return nil, err
-	}
-	user.ID, err = uuid.Parse(id)
-	if err != nil {
-		return nil, err
-	}
-
-	return &user, nil
+	return c.queryUser(query, token, time.Now().UTC())
 }
 
 func (c Client) CreateUser(params CreateUserParams) (*User, error) {
+	c, span := c.startSpan("CreateUser")
+	defer span.End()
+
 	id := uuid.New()
 
 	query := `
@@ -107,7 +174,7 @@ func (c Client) CreateUser(params CreateUserParams) (*User, error) {
 		VALUES
 		    (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
 	`
-	_, err := c.db.Exec(query, id.String(), params.Email, params.Password)
+	_, err := c.db.ExecContext(c.ctx, query, id.String(), params.Email, params.Password)
 	if err != nil {
 		return nil, err
 	}
@@ -116,32 +183,339 @@ func (c Client) CreateUser(params CreateUserParams) (*User, error) {
 }
 
 func (c Client) GetUser(id uuid.UUID) (*User, error) {
+	c, span := c.startSpan("GetUser")
+	defer span.End()
+
 	query := `
-		SELECT id, created_at, updated_at, email, password
+		SELECT id, created_at, updated_at, email, password, verified_at, failed_login_count, locked_until, totp_secret, totp_enabled_at, totp_last_step, role, disabled_at, quota_storage_bytes, quota_video_count
 		FROM users
 		WHERE id = ?
 	`
+	return c.queryUser(query, id.String())
+}
+
+// queryUser runs a query selecting id, created_at, updated_at, email,
+// password, verified_at, failed_login_count, locked_until, totp_secret,
+// totp_enabled_at, totp_last_step, role, disabled_at, quota_storage_bytes and
+// quota_video_count, returning nil if no user matched.
+func (c Client) queryUser(query string, args ...any) (*User, error) {
+	user, err := scanUser(c.db.QueryRowContext(c.ctx, query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return user, err
+}
+
+func scanUser(row interface{ Scan(...any) error }) (*User, error) {
 	var user User
-	var idStr string
-	err := c.db.QueryRow(query, id.String()).Scan(&idStr, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	var id string
+	err := row.Scan(
+		&id,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&user.Email,
+		&user.Password,
+		&user.VerifiedAt,
+		&user.FailedLoginCount,
+		&user.LockedUntil,
+		&user.TOTPSecret,
+		&user.TOTPEnabledAt,
+		&user.TOTPLastStep,
+		&user.Role,
+		&user.DisabledAt,
+		&user.Quota.StorageBytes,
+		&user.Quota.VideoCount,
+	)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, nil
-		}
 		return nil, err
 	}
-	user.ID, err = uuid.Parse(idStr)
+	user.ID, err = uuid.Parse(id)
 	if err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
-func (c Client) DeleteUser(id uuid.UUID) error {
+// RecordFailedLogin increments the user's consecutive failed login count and
+// returns the new count.
+func (c Client) RecordFailedLogin(id uuid.UUID) (int, error) {
+	c, span := c.startSpan("RecordFailedLogin")
+	defer span.End()
+
+	query := `
+		UPDATE users
+		SET failed_login_count = failed_login_count + 1
+		WHERE id = ?
+		RETURNING failed_login_count
+	`
+	var failures int
+	err := c.db.QueryRowContext(c.ctx, query, id.String()).Scan(&failures)
+	return failures, err
+}
+
+// LockUser rejects logins for the user until the given time.
+func (c Client) LockUser(id uuid.UUID, until time.Time) error {
+	c, span := c.startSpan("LockUser")
+	defer span.End()
+
+	query := `
+		UPDATE users
+		SET locked_until = ?
+		WHERE id = ?
+	`
+	_, err := c.db.ExecContext(c.ctx, query, until.UTC(), id.String())
+	return err
+}
+
+// ResetFailedLogins clears the failed login count and any lockout after a
+// successful login.
+func (c Client) ResetFailedLogins(id uuid.UUID) error {
+	c, span := c.startSpan("ResetFailedLogins")
+	defer span.End()
+
+	query := `
+		UPDATE users
+		SET failed_login_count = 0, locked_until = NULL
+		WHERE id = ?
+	`
+	_, err := c.db.ExecContext(c.ctx, query, id.String())
+	return err
+}
+
+func (c Client) SetUserRole(id uuid.UUID, role Role) error {
+	c, span := c.startSpan("SetUserRole")
+	defer span.End()
+
+	query := `
+		UPDATE users
+		SET role = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.ExecContext(c.ctx, query, string(role), id.String())
+	return err
+}
+
+// HasAdmin reports whether any user has the admin role.
+func (c Client) HasAdmin() (bool, error) {
+	c, span := c.startSpan("HasAdmin")
+	defer span.End()
+
+	var exists bool
+	err := c.db.QueryRowContext(c.ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE role = ?)", string(RoleAdmin)).Scan(&exists)
+	return exists, err
+}
+
+// DisableUser blocks the user from signing in and revokes their refresh
+// tokens in a single transaction.
+func (c Client) DisableUser(id uuid.UUID) error {
+	c, span := c.startSpan("DisableUser")
+	defer span.End()
+
+	tx, err := c.db.BeginTx(c.ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(c.ctx, `
+		UPDATE users
+		SET disabled_at = COALESCE(disabled_at, CURRENT_TIMESTAMP), updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, id.String())
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(c.ctx, `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND revoked_at IS NULL
+	`, id.String())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (c Client) EnableUser(id uuid.UUID) error {
+	c, span := c.startSpan("EnableUser")
+	defer span.End()
+
+	query := `
+		UPDATE users
+		SET disabled_at = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.ExecContext(c.ctx, query, id.String())
+	return err
+}
+
+// Quota limits how much a user can store. A nil limit means there is no
+// limit, or on a user's own quota, that their role's default applies.
+type Quota struct {
+	StorageBytes *int64 `json:"storage_bytes"`
+	VideoCount   *int   `json:"video_count"`
+}
+
+// SetUserQuota overrides the user's role default quota. Nil limits go back
+// to the role default.
+func (c Client) SetUserQuota(id uuid.UUID, quota Quota) error {
+	c, span := c.startSpan("SetUserQuota")
+	defer span.End()
+
 	query := `
-		DELETE FROM users
+		UPDATE users
+		SET quota_storage_bytes = ?, quota_video_count = ?, updated_at = CURRENT_TIMESTAMP
 		WHERE id = ?
 	`
-	_, err := c.db.Exec(query, id.String())
+	_, err := c.db.ExecContext(c.ctx, query, quota.StorageBytes, quota.VideoCount, id.String())
 	return err
 }
+
+type UserUsage struct {
+	VideoCount   int   `json:"video_count"`
+	StorageBytes int64 `json:"storage_bytes"`
+}
+
+// GetUserUsage totals the user's videos and the size of their uploaded
+// assets. Trashed videos count until they're purged, since their assets are
+// still stored.
+func (c Client) GetUserUsage(id uuid.UUID) (UserUsage, error) {
+	c, span := c.startSpan("GetUserUsage")
+	defer span.End()
+
+	query := `
+		SELECT COUNT(*), COALESCE(SUM(video_size + thumbnail_size), 0)
+		FROM videos
+		WHERE user_id = ?
+	`
+	var usage UserUsage
+	err := c.db.QueryRowContext(c.ctx, query, id.String()).Scan(&usage.VideoCount, &usage.StorageBytes)
+	return usage, err
+}
+
+// ReplaceUserPassword sets a new password hash and revokes the user's
+// refresh tokens in a single transaction, for when the old password can no
+// longer be trusted.
+func (c Client) ReplaceUserPassword(id uuid.UUID, hashedPassword string) error {
+	c, span := c.startSpan("ReplaceUserPassword")
+	defer span.End()
+
+	tx, err := c.db.BeginTx(c.ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(c.ctx, `
+		UPDATE users
+		SET password = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, hashedPassword, id.String())
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(c.ctx, `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND revoked_at IS NULL
+	`, id.String())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteUser removes a user along with their refresh tokens, playlists and
+// videos (including trashed ones) in a single transaction. It returns the
+// deleted videos so the caller can remove their stored assets.
+func (c Client) DeleteUser(id uuid.UUID) ([]Video, error) {
+	c, span := c.startSpan("DeleteUser")
+	defer span.End()
+
+	tx, err := c.db.BeginTx(c.ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	SELECT
+		id,
+		created_at,
+		updated_at,
+		title,
+		description,
+		thumbnail_url,
+		video_url,
+		user_id,
+		version,
+		deleted_at,
+		video_size,
+		thumbnail_size
+	FROM videos
+	WHERE user_id = ?
+	`
+	rows, err := tx.QueryContext(c.ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	videos := []Video{}
+	for rows.Next() {
+		var video Video
+		if err := rows.Scan(
+			&video.ID,
+			&video.CreatedAt,
+			&video.UpdatedAt,
+			&video.Title,
+			&video.Description,
+			&video.ThumbnailURL,
+			&video.VideoURL,
+			&video.UserID,
+			&video.Version,
+			&video.DeletedAt,
+			&video.VideoSize,
+			&video.ThumbnailSize,
+		); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, video := range videos {
+		if err := removeVideoFromPlaylists(tx, video.ID); err != nil {
+			return nil, fmt.Errorf("failed to remove video %s from playlists: %w", video.ID, err)
+		}
+	}
+
+	statements := []struct {
+		table string
+		query string
+	}{
+		{"playlist_videos", "DELETE FROM playlist_videos WHERE playlist_id IN (SELECT id FROM playlists WHERE user_id = ?)"},
+		{"playlists", "DELETE FROM playlists WHERE user_id = ?"},
+		{"videos", "DELETE FROM videos WHERE user_id = ?"},
+		{"refresh_tokens", "DELETE FROM refresh_tokens WHERE user_id = ?"},
+		{"password_reset_tokens", "DELETE FROM password_reset_tokens WHERE user_id = ?"},
+		{"email_verification_tokens", "DELETE FROM email_verification_tokens WHERE user_id = ?"},
+		{"totp_recovery_codes", "DELETE FROM totp_recovery_codes WHERE user_id = ?"},
+		{"user_identities", "DELETE FROM user_identities WHERE user_id = ?"},
+		{"users", "DELETE FROM users WHERE id = ?"},
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(c.ctx, statement.query, id.String()); err != nil {
+			return nil, fmt.Errorf("failed to delete from %s: %w", statement.table, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return videos, nil
+}
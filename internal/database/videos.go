@@ -5,16 +5,27 @@ import (
 	"errors"
 	"time"
 
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/api"
 	"github.com/google/uuid"
 )
 
+// ErrVideoConflict is returned by UpdateVideo when the row has been changed
+// since the video was read.
+var ErrVideoConflict = errors.New("video was modified concurrently")
+
+// ErrVideoLimitReached is returned by CreateVideo when the user already has
+// as many videos as they're allowed.
+var ErrVideoLimitReached = errors.New("video limit reached")
+
+// Video is a videos row: what clients see of it, plus bookkeeping that
+// stays on the server.
 type Video struct {
-	ID           uuid.UUID `json:"id"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	ThumbnailURL *string   `json:"thumbnail_url"`
-	VideoURL     *string   `json:"video_url"`
-	CreateVideoParams
+	api.Video
+	Version int `json:"-"`
+	// VideoSize and ThumbnailSize are the stored asset sizes in bytes, or 0
+	// if the asset hasn't been uploaded.
+	VideoSize     int64 `json:"-"`
+	ThumbnailSize int64 `json:"-"`
 }
 
 type CreateVideoParams struct {
@@ -24,6 +35,9 @@ type CreateVideoParams struct {
 }
 
 func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
+	c, span := c.startSpan("GetVideos")
+	defer span.End()
+
 	query := `
 	SELECT
 		id,
@@ -33,13 +47,100 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 		description,
 		thumbnail_url,
 		video_url,
-		user_id
+		user_id,
+		version,
+		deleted_at,
+		video_size,
+		thumbnail_size
 	FROM videos
-	WHERE user_id = ?
+	WHERE user_id = ? AND deleted_at IS NULL
 	ORDER BY created_at DESC
 	`
+	return c.queryVideos(query, userID)
+}
+
+// GetTrashedVideos returns the user's soft-deleted videos, most recently
+// trashed first.
+func (c Client) GetTrashedVideos(userID uuid.UUID) ([]Video, error) {
+	c, span := c.startSpan("GetTrashedVideos")
+	defer span.End()
+
+	query := `
+	SELECT
+		id,
+		created_at,
+		updated_at,
+		title,
+		description,
+		thumbnail_url,
+		video_url,
+		user_id,
+		version,
+		deleted_at,
+		video_size,
+		thumbnail_size
+	FROM videos
+	WHERE user_id = ? AND deleted_at IS NOT NULL
+	ORDER BY deleted_at DESC
+	`
+	return c.queryVideos(query, userID)
+}
 
-	rows, err := c.db.Query(query, userID)
+// GetVideosTrashedBefore returns every soft-deleted video that was trashed
+// before cutoff, regardless of owner.
+func (c Client) GetVideosTrashedBefore(cutoff time.Time) ([]Video, error) {
+	c, span := c.startSpan("GetVideosTrashedBefore")
+	defer span.End()
+
+	query := `
+	SELECT
+		id,
+		created_at,
+		updated_at,
+		title,
+		description,
+		thumbnail_url,
+		video_url,
+		user_id,
+		version,
+		deleted_at,
+		video_size,
+		thumbnail_size
+	FROM videos
+	WHERE deleted_at IS NOT NULL AND deleted_at < ?
+	ORDER BY deleted_at ASC
+	`
+	return c.queryVideos(query, cutoff.UTC())
+}
+
+// GetAllVideos returns every video, trashed or not, regardless of owner,
+// oldest first.
+func (c Client) GetAllVideos() ([]Video, error) {
+	c, span := c.startSpan("GetAllVideos")
+	defer span.End()
+
+	query := `
+	SELECT
+		id,
+		created_at,
+		updated_at,
+		title,
+		description,
+		thumbnail_url,
+		video_url,
+		user_id,
+		version,
+		deleted_at,
+		video_size,
+		thumbnail_size
+	FROM videos
+	ORDER BY created_at ASC
+	`
+	return c.queryVideos(query)
+}
+
+func (c Client) queryVideos(query string, args ...any) ([]Video, error) {
+	rows, err := c.db.QueryContext(c.ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -57,16 +158,27 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 			&video.ThumbnailURL,
 			&video.VideoURL,
 			&video.UserID,
+			&video.Version,
+			&video.DeletedAt,
+			&video.VideoSize,
+			&video.ThumbnailSize,
 		); err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
 	}
 
-	return videos, nil
+	return videos, rows.Err()
 }
 
-func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
+// CreateVideo inserts a video unless its owner already has maxVideos of
+// them, trashed ones included, in which case it returns
+// ErrVideoLimitReached. A nil maxVideos means no limit. The count and the
+// insert are one statement, so concurrent creates can't overshoot it.
+func (c Client) CreateVideo(params CreateVideoParams, maxVideos *int) (Video, error) {
+	c, span := c.startSpan("CreateVideo")
+	defer span.End()
+
 	id := uuid.New()
 	query := `
 	INSERT INTO videos (
@@ -76,17 +188,61 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		title,
 		description,
 		user_id
-	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+	)
+	SELECT ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?
+	WHERE ? IS NULL OR (SELECT COUNT(*) FROM videos WHERE user_id = ?) < ?
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	result, err := c.db.ExecContext(c.ctx, query, id, params.Title, params.Description, params.UserID, maxVideos, params.UserID, maxVideos)
+	if err != nil {
+		return Video{}, err
+	}
+	n, err := result.RowsAffected()
 	if err != nil {
 		return Video{}, err
 	}
+	if n == 0 {
+		return Video{}, ErrVideoLimitReached
+	}
 
 	return c.GetVideo(id)
 }
 
+// SetVideoSize records the size of a video file uploaded before sizes were
+// tracked. It does nothing if the video has since been given another file
+// or a size.
+func (c Client) SetVideoSize(id uuid.UUID, videoURL string, size int64) error {
+	c, span := c.startSpan("SetVideoSize")
+	defer span.End()
+
+	query := `
+	UPDATE videos
+	SET video_size = ?
+	WHERE id = ? AND video_url = ? AND video_size = 0
+	`
+	_, err := c.db.ExecContext(c.ctx, query, size, id, videoURL)
+	return err
+}
+
+// SetThumbnailSize is SetVideoSize for thumbnails.
+func (c Client) SetThumbnailSize(id uuid.UUID, thumbnailURL string, size int64) error {
+	c, span := c.startSpan("SetThumbnailSize")
+	defer span.End()
+
+	query := `
+	UPDATE videos
+	SET thumbnail_size = ?
+	WHERE id = ? AND thumbnail_url = ? AND thumbnail_size = 0
+	`
+	_, err := c.db.ExecContext(c.ctx, query, size, id, thumbnailURL)
+	return err
+}
+
+// GetVideo returns the video with the given ID. Trashed videos are treated
+// as missing; use GetTrashedVideo to look them up.
 func (c Client) GetVideo(id uuid.UUID) (Video, error) {
+	c, span := c.startSpan("GetVideo")
+	defer span.End()
+
 	query := `
 	SELECT
 		id,
@@ -96,13 +252,44 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 		description,
 		thumbnail_url,
 		video_url,
-		user_id
+		user_id,
+		version,
+		deleted_at,
+		video_size,
+		thumbnail_size
+	FROM videos
+	WHERE id = ? AND deleted_at IS NULL
+	`
+	return c.queryVideo(query, id)
+}
+
+func (c Client) GetTrashedVideo(id uuid.UUID) (Video, error) {
+	c, span := c.startSpan("GetTrashedVideo")
+	defer span.End()
+
+	query := `
+	SELECT
+		id,
+		created_at,
+		updated_at,
+		title,
+		description,
+		thumbnail_url,
+		video_url,
+		user_id,
+		version,
+		deleted_at,
+		video_size,
+		thumbnail_size
 	FROM videos
-	WHERE id = ?
+	WHERE id = ? AND deleted_at IS NOT NULL
 	`
+	return c.queryVideo(query, id)
+}
 
+func (c Client) queryVideo(query string, args ...any) (Video, error) {
 	var video Video
-	err := c.db.QueryRow(query, id).Scan(
+	err := c.db.QueryRowContext(c.ctx, query, args...).Scan(
 		&video.ID,
 		&video.CreatedAt,
 		&video.UpdatedAt,
@@ -110,7 +297,11 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 		&video.Description,
 		&video.ThumbnailURL,
 		&video.VideoURL,
-		&video.UserID)
+		&video.UserID,
+		&video.Version,
+		&video.DeletedAt,
+		&video.VideoSize,
+		&video.ThumbnailSize)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Video{}, nil
@@ -121,35 +312,122 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	return video, nil
 }
 
+// UpdateVideo writes every column of video, provided the row is still at
+// video.Version. On success the row's version is incremented; if another
+// update got there first, ErrVideoConflict is returned.
 func (c Client) UpdateVideo(video Video) error {
+	c, span := c.startSpan("UpdateVideo")
+	defer span.End()
+
 	query := `
 	UPDATE videos
 	SET
+		updated_at = CURRENT_TIMESTAMP,
+		version = version + 1,
 		title = ?,
 		description = ?,
 		thumbnail_url = ?,
 		video_url = ?,
-		user_id = ?
-	WHERE id = ?
+		user_id = ?,
+		video_size = ?,
+		thumbnail_size = ?
+	WHERE id = ? AND version = ? AND deleted_at IS NULL
 	`
 
-	_, err := c.db.Exec(
+	result, err := c.db.ExecContext(
+		c.ctx,
 		query,
 		video.Title,
 		video.Description,
 		&video.ThumbnailURL,
 		&video.VideoURL,
 		video.UserID,
+		video.VideoSize,
+		video.ThumbnailSize,
 		video.ID,
+		video.Version,
 	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrVideoConflict
+	}
+	return nil
+}
+
+// TrashVideo soft-deletes a video. It stays in the database, hidden from
+// GetVideo and GetVideos, until it is restored or purged.
+func (c Client) TrashVideo(id uuid.UUID) error {
+	c, span := c.startSpan("TrashVideo")
+	defer span.End()
+
+	query := `
+	UPDATE videos
+	SET
+		deleted_at = CURRENT_TIMESTAMP,
+		updated_at = CURRENT_TIMESTAMP,
+		version = version + 1
+	WHERE id = ? AND deleted_at IS NULL
+	`
+	_, err := c.db.ExecContext(c.ctx, query, id)
 	return err
 }
 
-func (c Client) DeleteVideo(id uuid.UUID) error {
+func (c Client) RestoreVideo(id uuid.UUID) error {
+	c, span := c.startSpan("RestoreVideo")
+	defer span.End()
+
 	query := `
-	DELETE FROM videos
-	WHERE id = ?
+	UPDATE videos
+	SET
+		deleted_at = NULL,
+		updated_at = CURRENT_TIMESTAMP,
+		version = version + 1
+	WHERE id = ? AND deleted_at IS NOT NULL
 	`
-	_, err := c.db.Exec(query, id)
+	_, err := c.db.ExecContext(c.ctx, query, id)
 	return err
 }
+
+// PurgeTrashedVideo permanently removes a video row if it's still in the
+// trash and was trashed before cutoff. It returns false if the video was
+// restored, or is otherwise no longer due, so the caller can leave its
+// assets alone. Callers are responsible for removing the assets of a purged
+// video.
+func (c Client) PurgeTrashedVideo(id uuid.UUID, cutoff time.Time) (bool, error) {
+	c, span := c.startSpan("PurgeTrashedVideo")
+	defer span.End()
+
+	tx, err := c.db.BeginTx(c.ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	DELETE FROM videos
+	WHERE id = ? AND deleted_at IS NOT NULL AND deleted_at < ?
+	`
+	result, err := tx.ExecContext(c.ctx, query, id, cutoff.UTC())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return false, nil
+	}
+
+	if err := removeVideoFromPlaylists(tx, id); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
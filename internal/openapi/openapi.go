@@ -0,0 +1,131 @@
+// Package openapi holds the API's OpenAPI document and checks request bodies
+// against it.
+//
+// Only the parts of JSON Schema the document uses are understood: types,
+// formats, enums, required and unknown properties, string lengths, minimums
+// and array items, plus $ref to the document's own schemas.
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+//go:embed openapi.json
+var document []byte
+
+// Document returns the OpenAPI document as JSON.
+func Document() []byte {
+	return document
+}
+
+// schema is the subset of an OpenAPI schema object that requests are
+// validated against.
+type schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Format               string             `json:"format"`
+	Nullable             bool               `json:"nullable"`
+	Enum                 []any              `json:"enum"`
+	Properties           map[string]*schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties any                `json:"additionalProperties"`
+	Items                *schema            `json:"items"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+}
+
+type spec struct {
+	Paths map[string]map[string]struct {
+		RequestBody *struct {
+			Content map[string]struct {
+				Schema *schema `json:"schema"`
+			} `json:"content"`
+		} `json:"requestBody"`
+	} `json:"paths"`
+	Components struct {
+		Schemas map[string]*schema `json:"schemas"`
+	} `json:"components"`
+}
+
+var (
+	parsed  = parseDocument()
+	schemas = parsed.Components.Schemas
+	// requestSchemas are the JSON request body schemas, keyed by route
+	// pattern as registered on the ServeMux, such as "POST /api/videos".
+	requestSchemas = jsonRequestSchemas(parsed)
+)
+
+// parseDocument reads the embedded document. It's part of the binary, so
+// any problem with it is a bug and panics.
+func parseDocument() spec {
+	var s spec
+	if err := json.Unmarshal(document, &s); err != nil {
+		panic(fmt.Sprintf("openapi: couldn't parse openapi.json: %v", err))
+	}
+	for name, component := range s.Components.Schemas {
+		if err := checkRefs(s.Components.Schemas, component); err != nil {
+			panic(fmt.Sprintf("openapi: schema %s: %v", name, err))
+		}
+	}
+	return s
+}
+
+func jsonRequestSchemas(s spec) map[string]*schema {
+	bodies := make(map[string]*schema)
+	for path, operations := range s.Paths {
+		for method, operation := range operations {
+			if operation.RequestBody == nil {
+				continue
+			}
+			media, ok := operation.RequestBody.Content["application/json"]
+			if !ok || media.Schema == nil {
+				continue
+			}
+			if err := checkRefs(s.Components.Schemas, media.Schema); err != nil {
+				panic(fmt.Sprintf("openapi: %s %s: %v", method, path, err))
+			}
+			bodies[strings.ToUpper(method)+" "+path] = media.Schema
+		}
+	}
+	return bodies
+}
+
+// checkRefs makes sure every $ref in s names one of schemas, so
+// validation never meets one that doesn't.
+func checkRefs(schemas map[string]*schema, s *schema) error {
+	if s == nil {
+		return nil
+	}
+	if s.Ref != "" {
+		name, ok := strings.CutPrefix(s.Ref, "#/components/schemas/")
+		if !ok || schemas[name] == nil {
+			return fmt.Errorf("unknown schema %q", s.Ref)
+		}
+	}
+	for _, property := range s.Properties {
+		if err := checkRefs(schemas, property); err != nil {
+			return err
+		}
+	}
+	return checkRefs(schemas, s.Items)
+}
+
+// resolve follows a $ref to the schema it names.
+func resolve(s *schema) *schema {
+	if s.Ref == "" {
+		return s
+	}
+	return schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+}
+
+// TakesJSON reports whether the route with the given ServeMux pattern takes
+// a JSON request body.
+func TakesJSON(pattern string) bool {
+	_, ok := requestSchemas[pattern]
+	return ok
+}
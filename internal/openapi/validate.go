@@ -0,0 +1,222 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// FieldError is one problem with a request body.
+type FieldError struct {
+	// Field is the path to the offending value, such as "title" or
+	// "video_ids[2]". It's empty for problems with the body as a whole.
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists everything wrong with a request body.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	problems := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		problems[i] = strings.TrimSpace(f.Field + " " + f.Message)
+	}
+	return "invalid request body: " + strings.Join(problems, "; ")
+}
+
+// ValidateRequest checks a JSON request body against the schema of the
+// route with the given ServeMux pattern. It returns a *ValidationError if
+// the body isn't JSON or doesn't match, and nil if it does or the route
+// doesn't take a JSON body.
+func ValidateRequest(pattern string, body []byte) error {
+	s, ok := requestSchemas[pattern]
+	if !ok {
+		return nil
+	}
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		return &ValidationError{Fields: []FieldError{{Message: "request body is required"}}}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value any
+	err := decoder.Decode(&value)
+	if err == nil && decoder.Decode(new(any)) != io.EOF {
+		err = errors.New("unexpected data after the JSON value")
+	}
+	if err != nil {
+		return &ValidationError{Fields: []FieldError{{Message: "must be valid JSON: " + err.Error()}}}
+	}
+
+	v := validator{}
+	v.validate(s, value, "")
+	if len(v.errs) > 0 {
+		return &ValidationError{Fields: v.errs}
+	}
+	return nil
+}
+
+type validator struct {
+	errs []FieldError
+}
+
+func (v *validator) fail(field, format string, args ...any) {
+	v.errs = append(v.errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) validate(s *schema, value any, field string) {
+	s = resolve(s)
+	if value == nil {
+		if !s.Nullable {
+			v.fail(field, "must not be null")
+		}
+		return
+	}
+
+	switch s.Type {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			v.fail(field, "must be an object")
+			return
+		}
+		v.validateObject(s, object, field)
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			v.fail(field, "must be an array")
+			return
+		}
+		if s.Items != nil {
+			for i, item := range items {
+				v.validate(s.Items, item, fmt.Sprintf("%s[%d]", field, i))
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			v.fail(field, "must be a string")
+			return
+		}
+		v.validateString(s, str, field)
+	case "integer", "number":
+		number, ok := value.(json.Number)
+		if !ok {
+			v.fail(field, "must be a number")
+			return
+		}
+		v.validateNumber(s, number, field)
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			v.fail(field, "must be true or false")
+			return
+		}
+	}
+
+	if len(s.Enum) > 0 && !slices.ContainsFunc(s.Enum, func(allowed any) bool {
+		return fmt.Sprint(allowed) == fmt.Sprint(value)
+	}) {
+		allowed := make([]string, len(s.Enum))
+		for i, a := range s.Enum {
+			allowed[i] = fmt.Sprint(a)
+		}
+		v.fail(field, "must be one of %s", strings.Join(allowed, ", "))
+	}
+}
+
+func (v *validator) validateObject(s *schema, object map[string]any, field string) {
+	for _, name := range s.Required {
+		if _, ok := object[name]; !ok {
+			v.fail(join(field, name), "is required")
+		}
+	}
+
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		property, ok := s.Properties[name]
+		if !ok {
+			if s.AdditionalProperties == false {
+				v.fail(join(field, name), "is not a known field")
+			}
+			continue
+		}
+		v.validate(property, object[name], join(field, name))
+	}
+}
+
+func (v *validator) validateString(s *schema, str, field string) {
+	length := utf8.RuneCountInString(str)
+	if s.MinLength != nil && length < *s.MinLength {
+		if *s.MinLength == 1 {
+			v.fail(field, "must not be empty")
+		} else {
+			v.fail(field, "must be at least %d characters", *s.MinLength)
+		}
+		return
+	}
+	if s.MaxLength != nil && length > *s.MaxLength {
+		v.fail(field, "must be at most %d characters", *s.MaxLength)
+		return
+	}
+
+	switch s.Format {
+	case "uuid":
+		if _, err := uuid.Parse(str); err != nil {
+			v.fail(field, "must be a UUID")
+		}
+	case "email":
+		if address, err := mail.ParseAddress(str); err != nil || address.Address != str {
+			v.fail(field, "must be an email address")
+		}
+	}
+}
+
+func (v *validator) validateNumber(s *schema, number json.Number, field string) {
+	var value float64
+	if s.Type == "integer" {
+		i, err := strconv.ParseInt(number.String(), 10, 64)
+		if err != nil {
+			v.fail(field, "must be an integer")
+			return
+		}
+		value = float64(i)
+	} else {
+		f, err := number.Float64()
+		if err != nil {
+			v.fail(field, "must be a number")
+			return
+		}
+		value = f
+	}
+
+	if s.Minimum != nil && value < *s.Minimum {
+		v.fail(field, "must be at least %s", strconv.FormatFloat(*s.Minimum, 'f', -1, 64))
+	}
+	if s.Maximum != nil && value > *s.Maximum {
+		v.fail(field, "must be at most %s", strconv.FormatFloat(*s.Maximum, 'f', -1, 64))
+	}
+}
+
+// join adds a property name to a field path.
+func join(field, name string) string {
+	if field == "" {
+		return name
+	}
+	return field + "." + name
+}
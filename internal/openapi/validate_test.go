@@ -0,0 +1,158 @@
+package openapi
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestValidateRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		body    string
+		want    []FieldError
+	}{
+		{
+			name:    "valid",
+			pattern: "POST /api/videos",
+			body:    `{"title": "Boots", "description": "A video"}`,
+		},
+		{
+			name:    "missing required field",
+			pattern: "POST /api/videos",
+			body:    `{"description": "A video"}`,
+			want:    []FieldError{{Field: "title", Message: "is required"}},
+		},
+		{
+			name:    "empty required string",
+			pattern: "POST /api/videos",
+			body:    `{"title": ""}`,
+			want:    []FieldError{{Field: "title", Message: "must not be empty"}},
+		},
+		{
+			name:    "unknown property",
+			pattern: "POST /api/videos",
+			body:    `{"title": "Boots", "user_id": "x"}`,
+			want:    []FieldError{{Field: "user_id", Message: "is not a known field"}},
+		},
+		{
+			name:    "wrong type",
+			pattern: "POST /api/videos",
+			body:    `{"title": 42, "description": ["a"]}`,
+			want: []FieldError{
+				{Field: "description", Message: "must be a string"},
+				{Field: "title", Message: "must be a string"},
+			},
+		},
+		{
+			name:    "body isn't an object",
+			pattern: "POST /api/videos",
+			body:    `"Boots"`,
+			want:    []FieldError{{Message: "must be an object"}},
+		},
+		{
+			name:    "null for a non-nullable field",
+			pattern: "POST /api/videos",
+			body:    `{"title": null}`,
+			want:    []FieldError{{Field: "title", Message: "must not be null"}},
+		},
+		{
+			name:    "null for nullable fields",
+			pattern: "PUT /api/videos/{videoID}",
+			body:    `{"title": null, "description": null}`,
+		},
+		{
+			name:    "nullable fields left out",
+			pattern: "PUT /api/videos/{videoID}",
+			body:    `{}`,
+		},
+		{
+			name:    "nullable integers",
+			pattern: "PUT /admin/users/{userID}/quota",
+			body:    `{"storage_bytes": null, "video_count": 3}`,
+		},
+		{
+			name:    "integer below minimum",
+			pattern: "PUT /admin/users/{userID}/quota",
+			body:    `{"storage_bytes": -1, "video_count": 1.5}`,
+			want: []FieldError{
+				{Field: "storage_bytes", Message: "must be at least 0"},
+				{Field: "video_count", Message: "must be an integer"},
+			},
+		},
+		{
+			name:    "enum",
+			pattern: "POST /api/playlists",
+			body:    `{"name": "Mix", "visibility": "secret"}`,
+			want:    []FieldError{{Field: "visibility", Message: "must be one of private, public"}},
+		},
+		{
+			name:    "array items",
+			pattern: "PUT /api/playlists/{playlistID}/videos",
+			body:    `{"video_ids": ["0195d2b4-3c1c-7d4e-9f4e-2a9b1c3d4e5f", "nope"]}`,
+			want:    []FieldError{{Field: "video_ids[1]", Message: "must be a UUID"}},
+		},
+		{
+			name:    "email format",
+			pattern: "POST /api/users",
+			body:    `{"email": "Boots <boots@example.com>", "password": "x"}`,
+			want:    []FieldError{{Field: "email", Message: "must be an email address"}},
+		},
+		{
+			name:    "empty body",
+			pattern: "POST /api/videos",
+			body:    " \n",
+			want:    []FieldError{{Message: "request body is required"}},
+		},
+		{
+			name:    "trailing data",
+			pattern: "POST /api/videos",
+			body:    `{"title": "Boots"} {"title": "Again"}`,
+			want:    []FieldError{{Message: "must be valid JSON: unexpected data after the JSON value"}},
+		},
+		{
+			name:    "route without a JSON body",
+			pattern: "GET /api/videos",
+			body:    `not json`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateRequest(tc.pattern, []byte(tc.body))
+			if tc.want == nil {
+				if err != nil {
+					t.Fatalf("ValidateRequest = %v, want nil", err)
+				}
+				return
+			}
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("ValidateRequest = %v, want a *ValidationError", err)
+			}
+			if !reflect.DeepEqual(validationErr.Fields, tc.want) {
+				t.Errorf("fields = %+v, want %+v", validationErr.Fields, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidateRequestInvalidJSON(t *testing.T) {
+	err := ValidateRequest("POST /api/videos", []byte(`{"title": `))
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("ValidateRequest = %v, want a *ValidationError", err)
+	}
+	if len(validationErr.Fields) != 1 || validationErr.Fields[0].Field != "" {
+		t.Errorf("fields = %+v, want one error for the whole body", validationErr.Fields)
+	}
+}
+
+func TestTakesJSON(t *testing.T) {
+	if !TakesJSON("POST /api/videos") {
+		t.Error(`TakesJSON("POST /api/videos") = false`)
+	}
+	if TakesJSON("POST /api/video_upload/{videoID}") {
+		t.Error(`TakesJSON("POST /api/video_upload/{videoID}") = true for a multipart upload`)
+	}
+}
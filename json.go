@@ -2,16 +2,18 @@ package main
 
 import (
 	"encoding/json"
-	"log"
 	"net/http"
 )
 
 func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
+	logger := responseLogger(w).With("status", code, "message", msg)
 	if err != nil {
-		log.Println(err)
+		logger = logger.With("error", err)
 	}
 	if code > 499 {
-		log.Printf("Responding with 5XX error: %s", msg)
+		logger.Error("Responding with 5XX error")
+	} else if err != nil {
+		logger.Info("Responding with error")
 	}
 	type errorResponse struct {
 		Error string `json:"error"`
@@ -25,7 +27,7 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	dat, err := json.Marshal(payload)
 	if err != nil {
-		log.Printf("Error marshalling JSON: %s", err)
+		responseLogger(w).Error("Error marshalling JSON", "error", err)
 		w.WriteHeader(500)
 		return
 	}
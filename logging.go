@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	requestIDHeader    = "X-Request-ID"
+	maxRequestIDLength = 128
+)
+
+// newLogger builds the server's logger. format is "text" or "json" and level
+// is one of slog's level names, such as "debug" or "info".
+func newLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var logLevel slog.Level
+	if level != "" {
+		if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+			return nil, fmt.Errorf("invalid log level %q", level)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: logLevel}
+
+	switch format {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("log format must be \"text\" or \"json\", got %q", format)
+	}
+}
+
+type requestLogKey struct{}
+
+// requestLog holds the fields every log line about a request carries. The
+// user ID is only known once the access token has been checked, so it's
+// filled in as the request makes its way through the middleware.
+type requestLog struct {
+	base *slog.Logger
+	r    *http.Request
+
+	mu     sync.Mutex
+	userID string
+}
+
+func (l *requestLog) setUserID(userID uuid.UUID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.userID = userID.String()
+}
+
+func (l *requestLog) logger() *slog.Logger {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	logger := l.base
+	if l.userID != "" {
+		logger = logger.With("user_id", l.userID)
+	}
+	// ServeMux fills in path values on the request it routes, which is the
+	// one held here, so they're available from the handler onwards.
+	if videoID := l.r.PathValue("videoID"); videoID != "" {
+		logger = logger.With("video_id", videoID)
+	}
+	return logger
+}
+
+// requestLogger returns the logger for the request, carrying its request ID
+// and whichever of the user and video IDs are known.
+func requestLogger(r *http.Request) *slog.Logger {
+	return contextLogger(r.Context())
+}
+
+func contextLogger(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(requestLogKey{}).(*requestLog); ok {
+		return l.logger()
+	}
+	return slog.Default()
+}
+
+// setLogUserID adds the authenticated user to the request's log fields.
+func setLogUserID(r *http.Request, userID uuid.UUID) {
+	if l, ok := r.Context().Value(requestLogKey{}).(*requestLog); ok {
+		l.setUserID(userID)
+	}
+}
+
+// loggingResponseWriter records the status code for the access log and lets
+// respondWithError find the request's logger.
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	log    *requestLog
+	status int
+}
+
+func (w *loggingResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *loggingResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// responseLogger returns the logger for the request being answered on w.
+func responseLogger(w http.ResponseWriter) *slog.Logger {
+	if lw, ok := w.(*loggingResponseWriter); ok {
+		return lw.log.logger()
+	}
+	return slog.Default()
+}
+
+// observeRequests gives each request an ID, reusing the client's
+// X-Request-ID when it sends a sensible one, and puts a logger for it in the
+// request context. Once the request has been served it's logged and counted
+// in the HTTP metrics.
+func (cfg *apiConfig) observeRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		requestID := r.Header.Get(requestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = uuid.NewString()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+
+		base := cfg.logger.With("request_id", requestID)
+		if spanContext := trace.SpanContextFromContext(r.Context()); spanContext.IsValid() {
+			base = base.With("trace_id", spanContext.TraceID().String())
+		}
+		l := &requestLog{base: base}
+		r = r.WithContext(context.WithValue(r.Context(), requestLogKey{}, l))
+		l.r = r
+
+		lw := &loggingResponseWriter{ResponseWriter: w, log: l}
+		next.ServeHTTP(lw, r)
+
+		if lw.status == 0 {
+			lw.status = http.StatusOK
+		}
+		duration := time.Since(start)
+		nameRequestSpan(r)
+		observeHTTPRequest(r, lw.status, duration)
+		level := slog.LevelInfo
+		if isProbe(r) && lw.status < 500 {
+			level = slog.LevelDebug
+		}
+		l.logger().Log(r.Context(), level, "Served request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", lw.status,
+			"duration", duration,
+			"ip", clientIP(r),
+		)
+	})
+}
+
+// isProbe reports whether r is a metrics scrape or health check. These come
+// in every few seconds, so they're left out of traces and only logged at
+// debug level unless they fail.
+func isProbe(r *http.Request) bool {
+	switch r.URL.Path {
+	case "/metrics", "/healthz", "/readyz":
+		return true
+	}
+	return false
+}
+
+// validRequestID accepts IDs that are safe to echo back and write to logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	return strings.IndexFunc(id, func(c rune) bool {
+		return !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.:", c))
+	}) == -1
+}
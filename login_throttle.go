@@ -0,0 +1,130 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// Per-IP limits, kept in memory.
+	ipLoginFreeFailures = 10
+	ipLoginBaseBackoff  = time.Second
+	ipLoginMaxBackoff   = 15 * time.Minute
+	ipLoginForgetAfter  = time.Hour
+
+	// Per-account limits, kept in the users table.
+	accountLoginFreeFailures = 5
+	accountLoginBaseLockout  = time.Minute
+	accountLoginMaxLockout   = time.Hour
+)
+
+// backoff returns how long to block after the given number of consecutive
+// failures: nothing for the first free failures, then base, doubling with
+// each further failure up to max.
+func backoff(failures, free int, base, max time.Duration) time.Duration {
+	if failures < free {
+		return 0
+	}
+	exp := failures - free
+	if exp > 30 {
+		return max
+	}
+	d := base * time.Duration(math.Pow(2, float64(exp)))
+	if d > max {
+		return max
+	}
+	return d
+}
+
+type loginThrottleEntry struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// loginThrottle tracks failed logins per client IP.
+type loginThrottle struct {
+	mu        sync.Mutex
+	entries   map[string]*loginThrottleEntry
+	lastSweep time.Time
+}
+
+func newLoginThrottle() *loginThrottle {
+	return &loginThrottle{
+		entries: map[string]*loginThrottleEntry{},
+	}
+}
+
+// blockedFor returns how much longer ip must wait before trying again.
+func (t *loginThrottle) blockedFor(ip string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry, ok := t.entries[ip]
+	if !ok {
+		return 0
+	}
+	return time.Until(entry.blockedUntil)
+}
+
+func (t *loginThrottle) recordFailure(ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.sweep(now)
+
+	entry, ok := t.entries[ip]
+	if !ok {
+		entry = &loginThrottleEntry{}
+		t.entries[ip] = entry
+	}
+	if now.Sub(entry.lastFailure) > ipLoginForgetAfter {
+		entry.failures = 0
+	}
+	entry.failures++
+	entry.lastFailure = now
+	entry.blockedUntil = now.Add(backoff(entry.failures, ipLoginFreeFailures, ipLoginBaseBackoff, ipLoginMaxBackoff))
+}
+
+func (t *loginThrottle) recordSuccess(ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.entries, ip)
+}
+
+// sweep drops entries that have been quiet long enough to forget, at most
+// once a minute. The caller must hold t.mu.
+func (t *loginThrottle) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < time.Minute {
+		return
+	}
+	t.lastSweep = now
+	for ip, entry := range t.entries {
+		if now.Sub(entry.lastFailure) > ipLoginForgetAfter {
+			delete(t.entries, ip)
+		}
+	}
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func respondWithRetryAfter(w http.ResponseWriter, retryAfter time.Duration, msg string) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	respondWithError(w, http.StatusTooManyRequests, msg, nil)
+}
@@ -2,136 +2,317 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/config"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/config"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/aws/aws-sdk-go-v2/otelaws"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 type apiConfig struct {
-	db               database.Client
-	jwtSecret        string
-	platform         string
-	filepathRoot     string
-	assetsRoot       string
-	s3Bucket         string
-	s3Region         string
-	s3CfDistribution string
-	port             string
-	s3Client         *s3.Client
+	db                   database.Client
+	jwtKeys              *auth.KeySet
+	platform             string
+	filepathRoot         string
+	assetsRoot           string
+	s3Bucket             string
+	s3Region             string
+	s3CfDistribution     string
+	port                 string
+	s3Client             *s3.Client
+	trashRetention       time.Duration
+	mailer               mailer.Mailer
+	requireVerifiedEmail bool
+	readinessErrors      bool
+	loginThrottle        *loginThrottle
+	readiness            *readinessCache
+	oidc                 *oidcClient
+	roleQuotas           map[database.Role]database.Quota
+	logger               *slog.Logger
+	tempDir              string
+	lifecycle            *lifecycle
 }
 
 func main() {
 	godotenv.Load(".env")
 
-	pathToDB := os.Getenv("DB_PATH")
-	if pathToDB == "" {
-		log.Fatal("DB_URL must be set")
+	flags := flag.NewFlagSet("tubely", flag.ExitOnError)
+	flags.Usage = func() { printUsage(flags) }
+	printConfig := flags.Bool("print-config", false, "print the effective configuration, with secrets redacted, and exit")
+	conf, err := config.Load(flags, os.Args[1:])
+
+	name := "serve"
+	if flags.NArg() > 0 {
+		name = flags.Arg(0)
+	}
+	cmd, ok := findCommand(name)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+		flags.Usage()
+		os.Exit(2)
 	}
 
-	db, err := database.NewClient(pathToDB)
+	err = errors.Join(err, cmd.checkConfig(conf))
+	if *printConfig {
+		conf.Print(os.Stdout)
+	}
 	if err != nil {
-		log.Fatalf("Couldn't connect to database: %v", err)
+		log.Fatalf("Invalid configuration:\n%v", err)
 	}
-
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Fatal("JWT_SECRET environment variable is not set")
+	if *printConfig {
+		return
 	}
 
-	platform := os.Getenv("PLATFORM")
-	if platform == "" {
-		log.Fatal("PLATFORM environment variable is not set")
+	logger, err := newLogger(os.Stderr, conf.LogFormat, conf.LogLevel)
+	if err != nil {
+		log.Fatalf("Invalid logging configuration: %v", err)
+	}
+	// Anything still using the log package goes through the same handler.
+	slog.SetDefault(logger)
+	if conf.File != "" {
+		logger.Info("Read config file", "path", conf.File)
 	}
 
-	filepathRoot := os.Getenv("FILEPATH_ROOT")
-	if filepathRoot == "" {
-		log.Fatal("FILEPATH_ROOT environment variable is not set")
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		log.Fatalf("Couldn't set up tracing: %v", err)
 	}
 
-	assetsRoot := os.Getenv("ASSETS_ROOT")
-	if assetsRoot == "" {
-		log.Fatal("ASSETS_ROOT environment variable is not set")
+	db, err := database.NewClient(conf.DBPath, observeDBQuery)
+	if err != nil {
+		log.Fatalf("Couldn't connect to database: %v", err)
 	}
 
-	s3Bucket := os.Getenv("S3_BUCKET")
-	if s3Bucket == "" {
-		log.Fatal("S3_BUCKET environment variable is not set")
+	cfg, err := newAPIConfig(conf, db, logger)
+	if err != nil {
+		log.Fatalf("Couldn't set up: %v", err)
 	}
 
-	s3Region := os.Getenv("S3_REGION")
-	if s3Region == "" {
-		log.Fatal("S3_REGION environment variable is not set")
+	var args []string
+	if flags.NArg() > 0 {
+		args = flags.Args()[1:]
 	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	runErr := cmd.run(ctx, cfg, conf, args)
+	stop()
 
-	s3CfDistribution := os.Getenv("S3_CF_DISTRO")
-	if s3CfDistribution == "" {
-		log.Fatal("S3_CF_DISTRO environment variable is not set")
+	if err := os.RemoveAll(cfg.tempDir); err != nil {
+		logger.Error("Couldn't remove temp directory", "error", err)
+	}
+	if err := db.Close(); err != nil {
+		logger.Error("Couldn't close database", "error", err)
+	}
+	if err := shutdownTracing(context.Background()); err != nil {
+		logger.Error("Couldn't flush traces", "error", err)
+	}
+	if runErr != nil {
+		logger.Error("Command failed", "command", cmd.name, "error", runErr)
+		os.Exit(1)
 	}
+}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		log.Fatal("PORT environment variable is not set")
+// newAPIConfig sets up what every command shares: the database, storage and
+// quotas. The server adds what only it needs, such as token keys and the
+// mailer.
+func newAPIConfig(conf config.Config, db database.Client, logger *slog.Logger) (*apiConfig, error) {
+	awsConfig, err := awsconfig.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("couldn't load AWS config: %w", err)
 	}
+	otelaws.AppendMiddlewares(&awsConfig.APIOptions)
 
-	awsConfig, err := config.LoadDefaultConfig(context.Background())
+	// Uploads are staged here so whatever a cancelled request leaves behind
+	// is removed on shutdown.
+	tempDir, err := os.MkdirTemp("", "tubely-uploads-")
 	if err != nil {
-		log.Fatal("Error loading AWS config:", err)
+		return nil, fmt.Errorf("couldn't create temp directory: %w", err)
+	}
+
+	return &apiConfig{
+		db:                   db,
+		platform:             conf.Platform,
+		filepathRoot:         conf.FilepathRoot,
+		assetsRoot:           conf.AssetsRoot,
+		s3Bucket:             conf.S3Bucket,
+		s3Region:             conf.S3Region,
+		s3CfDistribution:     conf.S3CfDistro,
+		port:                 conf.Port,
+		s3Client:             s3.NewFromConfig(awsConfig),
+		trashRetention:       conf.TrashRetention,
+		requireVerifiedEmail: conf.RequireVerifiedEmail,
+		readinessErrors:      conf.ReadinessErrors,
+		loginThrottle:        newLoginThrottle(),
+		readiness:            newReadinessCache(),
+		roleQuotas:           roleQuotas(conf),
+		logger:               logger,
+		tempDir:              tempDir,
+		lifecycle:            newLifecycle(),
+	}, nil
+}
+
+// commandServe runs the HTTP server until it receives SIGINT or SIGTERM.
+func commandServe(ctx context.Context, cfg *apiConfig, conf config.Config, args []string) error {
+	if len(args) > 0 {
+		return errors.New("serve takes no arguments")
+	}
+
+	cfg.jwtKeys = auth.NewKeySet(conf.JWTSecret)
+	if conf.JWTSigningKeyFile != "" {
+		keyPEM, err := os.ReadFile(conf.JWTSigningKeyFile)
+		if err != nil {
+			return fmt.Errorf("couldn't read JWT_SIGNING_KEY_FILE: %w", err)
+		}
+		err = cfg.jwtKeys.SetSigningKey(keyPEM)
+		if err != nil {
+			return fmt.Errorf("couldn't load JWT signing key '%s': %w", conf.JWTSigningKeyFile, err)
+		}
+	}
+	for _, keyFile := range conf.JWTVerificationKeyFiles {
+		keyPEM, err := os.ReadFile(keyFile)
+		if err != nil {
+			return fmt.Errorf("couldn't read JWT verification key: %w", err)
+		}
+		err = cfg.jwtKeys.AddVerificationKey(keyPEM)
+		if err != nil {
+			return fmt.Errorf("couldn't load JWT verification key '%s': %w", keyFile, err)
+		}
 	}
 
-	s3Client := s3.NewFromConfig(awsConfig)
+	switch conf.Mailer {
+	case "log":
+		cfg.mailer = mailer.NewLogMailer(os.Stderr)
+		if conf.MailLogFile != "" {
+			f, err := os.OpenFile(conf.MailLogFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+			if err != nil {
+				return fmt.Errorf("couldn't open MAIL_LOG_FILE: %w", err)
+			}
+			defer f.Close()
+			cfg.mailer = mailer.NewLogMailer(f)
+		}
+	case "smtp":
+		cfg.mailer = mailer.NewSMTPMailer(conf.SMTPHost, conf.SMTPPort, conf.SMTPUsername, conf.SMTPPassword, conf.MailFrom)
+	}
 
-	cfg := apiConfig{
-		db:               db,
-		jwtSecret:        jwtSecret,
-		platform:         platform,
-		filepathRoot:     filepathRoot,
-		assetsRoot:       assetsRoot,
-		s3Bucket:         s3Bucket,
-		s3Region:         s3Region,
-		s3CfDistribution: s3CfDistribution,
-		port:             port,
-		s3Client:         s3Client,
+	if conf.OIDCIssuerURL != "" {
+		oidcRedirectURL := conf.OIDCRedirectURL
+		if oidcRedirectURL == "" {
+			oidcRedirectURL = "http://localhost:" + conf.Port + "/api/oidc/callback"
+		}
+		var err error
+		cfg.oidc, err = newOIDCClient(ctx, conf.OIDCIssuerURL, conf.OIDCClientID, conf.OIDCClientSecret, oidcRedirectURL)
+		if err != nil {
+			return fmt.Errorf("couldn't set up SSO login: %w", err)
+		}
 	}
 
-	err = cfg.ensureAssetsDir()
+	err := cfg.ensureAssetsDir()
 	if err != nil {
-		log.Fatalf("Couldn't create assets directory: %v", err)
+		return fmt.Errorf("couldn't create assets directory: %w", err)
 	}
 
 	mux := http.NewServeMux()
-	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
+	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(conf.FilepathRoot)))
 	mux.Handle("/app/", appHandler)
 
-	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
+	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(conf.AssetsRoot)))
 	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
 
+	mux.HandleFunc("GET /.well-known/jwks.json", cfg.handlerJWKS)
+	mux.Handle("GET /metrics", promhttp.Handler())
+	mux.HandleFunc("GET /healthz", cfg.handlerHealthz)
+	mux.HandleFunc("GET /readyz", cfg.handlerReadyz)
+	mux.HandleFunc("GET /api/openapi.json", cfg.handlerOpenAPI)
+
 	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
+	mux.HandleFunc("POST /api/login/mfa", cfg.handlerLoginMFA)
+	mux.HandleFunc("GET /api/oidc/login", cfg.handlerOIDCLogin)
+	mux.HandleFunc("GET /api/oidc/callback", cfg.handlerOIDCCallback)
 	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
 	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
+	mux.HandleFunc("GET /api/sessions", cfg.handlerSessionsRetrieve)
+	mux.HandleFunc("DELETE /api/sessions", cfg.handlerSessionsDeleteAll)
+	mux.HandleFunc("DELETE /api/sessions/{sessionID}", cfg.handlerSessionDelete)
 
 	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
+	mux.HandleFunc("DELETE /api/users/me", cfg.handlerUsersDelete)
+	mux.HandleFunc("GET /api/users/me/usage", cfg.handlerUsageGet)
+	mux.HandleFunc("PUT /api/users/me/password", cfg.handlerPasswordChange)
+	mux.HandleFunc("POST /api/users/me/verification", cfg.handlerEmailVerificationResend)
+	mux.HandleFunc("POST /api/users/verify", cfg.handlerEmailVerify)
+	mux.HandleFunc("POST /api/users/me/totp", cfg.handlerTOTPEnroll)
+	mux.HandleFunc("POST /api/users/me/totp/confirm", cfg.handlerTOTPConfirm)
+	mux.HandleFunc("DELETE /api/users/me/totp", cfg.handlerTOTPDisable)
+	mux.HandleFunc("POST /api/password_reset", cfg.handlerPasswordResetRequest)
+	mux.HandleFunc("POST /api/password_reset/confirm", cfg.handlerPasswordResetConfirm)
 
 	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
 	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
 	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
 	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
+	mux.HandleFunc("GET /api/videos/trash", cfg.handlerTrashRetrieve)
+	mux.HandleFunc("POST /api/videos/{videoID}/restore", cfg.handlerVideoRestore)
 	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
+	mux.HandleFunc("PUT /api/videos/{videoID}", cfg.handlerVideoMetaUpdate)
 	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
 
-	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
+	mux.HandleFunc("POST /api/playlists", cfg.handlerPlaylistCreate)
+	mux.HandleFunc("GET /api/playlists", cfg.handlerPlaylistsRetrieve)
+	mux.HandleFunc("GET /api/playlists/{playlistID}", cfg.handlerPlaylistGet)
+	mux.HandleFunc("PUT /api/playlists/{playlistID}", cfg.handlerPlaylistUpdate)
+	mux.HandleFunc("DELETE /api/playlists/{playlistID}", cfg.handlerPlaylistDelete)
+	mux.HandleFunc("POST /api/playlists/{playlistID}/videos", cfg.handlerPlaylistVideoAdd)
+	mux.HandleFunc("PUT /api/playlists/{playlistID}/videos", cfg.handlerPlaylistVideosReorder)
+	mux.HandleFunc("DELETE /api/playlists/{playlistID}/videos/{videoID}", cfg.handlerPlaylistVideoRemove)
+
+	mux.HandleFunc("GET /admin/users", cfg.handlerAdminUsersRetrieve)
+	mux.HandleFunc("GET /admin/users/{userID}", cfg.handlerAdminUserGet)
+	mux.HandleFunc("PUT /admin/users/{userID}/role", cfg.handlerAdminUserRoleUpdate)
+	mux.HandleFunc("PUT /admin/users/{userID}/quota", cfg.handlerAdminUserQuotaUpdate)
+	mux.HandleFunc("POST /admin/users/{userID}/disable", cfg.handlerAdminUserDisable)
+	mux.HandleFunc("POST /admin/users/{userID}/enable", cfg.handlerAdminUserEnable)
+	mux.HandleFunc("POST /admin/users/{userID}/password_reset", cfg.handlerAdminUserPasswordReset)
+	mux.HandleFunc("POST /admin/users/{userID}/impersonate", cfg.handlerAdminUserImpersonate)
+	mux.HandleFunc("GET /admin/videos/{videoID}", cfg.handlerAdminVideoGet)
+	mux.HandleFunc("GET /admin/audit_events", cfg.handlerAdminAuditEventsRetrieve)
+	mux.HandleFunc("GET /admin/audit_events/export", cfg.handlerAdminAuditEventsExport)
+
+	cfg.lifecycle.goJob(func() {
+		cfg.runTrashPurger(cfg.lifecycle.stopping, time.Hour)
+	})
 
 	srv := &http.Server{
-		Addr:    ":" + port,
-		Handler: mux,
+		Addr: ":" + conf.Port,
+		Handler: cfg.lifecycle.trackRequests(otelhttp.NewHandler(
+			cfg.observeRequests(cfg.rejectDisabledUsers(validateRequestBodies(mux))),
+			"http.server",
+			otelhttp.WithFilter(func(r *http.Request) bool {
+				return !isProbe(r)
+			}),
+		)),
 	}
 
-	log.Printf("Serving on: http://localhost:%s/app/\n", port)
-	log.Fatal(srv.ListenAndServe())
+	cfg.logger.Info("Serving on: http://localhost:" + conf.Port + "/app/")
+	if err := cfg.serve(ctx, srv, conf.ShutdownTimeout); err != nil {
+		return err
+	}
+	cfg.logger.Info("Shut down")
+	return nil
 }
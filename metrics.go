@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tubely_http_requests_total",
+		Help: "HTTP requests served, by route pattern and status code.",
+	}, []string{"route", "status"})
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tubely_http_request_duration_seconds",
+		Help:    "Time taken to serve HTTP requests, by route pattern.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route"})
+
+	uploadsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tubely_uploads_total",
+		Help: "Successful uploads, by asset kind and media type.",
+	}, []string{"kind", "media_type"})
+	uploadBytesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tubely_upload_bytes_total",
+		Help: "Bytes received in successful uploads, by asset kind and media type.",
+	}, []string{"kind", "media_type"})
+
+	commandDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tubely_command_duration_seconds",
+		Help:    "Time taken by external commands such as ffprobe and ffmpeg.",
+		Buckets: []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"command"})
+	commandFailuresTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tubely_command_failures_total",
+		Help: "External commands that failed to run or exited non-zero.",
+	}, []string{"command"})
+
+	s3RequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tubely_s3_request_duration_seconds",
+		Help:    "Time taken by S3 requests, by operation.",
+		Buckets: []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"operation"})
+	s3RequestErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tubely_s3_request_errors_total",
+		Help: "S3 requests that failed, by operation.",
+	}, []string{"operation"})
+
+	dbQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tubely_db_query_duration_seconds",
+		Help:    "Time taken by database statements, by kind (exec or query).",
+		Buckets: []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1},
+	}, []string{"op"})
+	dbQueryErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tubely_db_query_errors_total",
+		Help: "Database statements that failed, by kind (exec or query).",
+	}, []string{"op"})
+)
+
+// observeHTTPRequest records a served request under the ServeMux pattern it
+// matched, so paths with IDs in them don't each get their own series.
+func observeHTTPRequest(r *http.Request, status int, duration time.Duration) {
+	route := r.Pattern
+	if route == "" {
+		route = "unmatched"
+	}
+	httpRequestsTotal.WithLabelValues(route, strconv.Itoa(status)).Inc()
+	httpRequestDuration.WithLabelValues(route).Observe(duration.Seconds())
+}
+
+func observeUpload(kind, mediaType string, size int64) {
+	uploadsTotal.WithLabelValues(kind, mediaType).Inc()
+	uploadBytesTotal.WithLabelValues(kind, mediaType).Add(float64(size))
+}
+
+// timeCommand runs cmd, recording how long it took and whether it failed.
+func timeCommand(name string, cmd *exec.Cmd) error {
+	start := time.Now()
+	err := cmd.Run()
+	commandDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	if err != nil {
+		commandFailuresTotal.WithLabelValues(name).Inc()
+	}
+	return err
+}
+
+func observeS3Request(operation string, start time.Time, err error) {
+	s3RequestDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		s3RequestErrorsTotal.WithLabelValues(operation).Inc()
+	}
+}
+
+func observeDBQuery(op string, duration time.Duration, err error) {
+	dbQueryDuration.WithLabelValues(op).Observe(duration.Seconds())
+	if err != nil {
+		dbQueryErrorsTotal.WithLabelValues(op).Inc()
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/config"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	maxVideoUploadBytes     = 1 << 30
+	maxThumbnailUploadBytes = 10 << 20
+	// multipartOverhead is slack for the boundaries and part headers around
+	// an uploaded file.
+	multipartOverhead = 16 << 10
+)
+
+// roleQuotas returns the default quota for each role, overridden by
+// QUOTA_<ROLE>_STORAGE_BYTES and QUOTA_<ROLE>_VIDEO_COUNT when set. Either
+// can be "unlimited".
+func roleQuotas(conf config.Config) map[database.Role]database.Quota {
+	quotas := map[database.Role]database.Quota{
+		database.RoleUser:      {StorageBytes: ptr(int64(5 << 30)), VideoCount: ptr(50)},
+		database.RoleModerator: {StorageBytes: ptr(int64(20 << 30)), VideoCount: ptr(200)},
+		database.RoleAdmin:     {},
+	}
+	overrides := map[database.Role]struct{ storageBytes, videoCount config.QuotaLimit }{
+		database.RoleUser:      {conf.QuotaUserStorageBytes, conf.QuotaUserVideoCount},
+		database.RoleModerator: {conf.QuotaModeratorStorageBytes, conf.QuotaModeratorVideoCount},
+		database.RoleAdmin:     {conf.QuotaAdminStorageBytes, conf.QuotaAdminVideoCount},
+	}
+
+	for role, quota := range quotas {
+		override := overrides[role]
+		if override.storageBytes.Set {
+			quota.StorageBytes = override.storageBytes.Limit()
+		}
+		if override.videoCount.Set {
+			if limit := override.videoCount.Limit(); limit == nil {
+				quota.VideoCount = nil
+			} else {
+				quota.VideoCount = ptr(int(*limit))
+			}
+		}
+		quotas[role] = quota
+	}
+
+	return quotas
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// quotaFor returns the user's own quota, falling back to their role's
+// default for any limit that isn't set.
+func (cfg *apiConfig) quotaFor(user database.User) database.Quota {
+	quota := cfg.roleQuotas[user.Role]
+	if user.Quota.StorageBytes != nil {
+		quota.StorageBytes = user.Quota.StorageBytes
+	}
+	if user.Quota.VideoCount != nil {
+		quota.VideoCount = user.Quota.VideoCount
+	}
+	return quota
+}
+
+// getUsageAndQuota loads the user's current usage and quota. On failure it
+// writes the error response and returns false.
+func (cfg *apiConfig) getUsageAndQuota(w http.ResponseWriter, r *http.Request, userID uuid.UUID) (database.UserUsage, database.Quota, bool) {
+	user, err := cfg.db.WithContext(r.Context()).GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return database.UserUsage{}, database.Quota{}, false
+	}
+	if user == nil {
+		respondWithError(w, http.StatusUnauthorized, "User not found", nil)
+		return database.UserUsage{}, database.Quota{}, false
+	}
+
+	usage, err := cfg.db.WithContext(r.Context()).GetUserUsage(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get usage", err)
+		return database.UserUsage{}, database.Quota{}, false
+	}
+
+	return usage, cfg.quotaFor(*user), true
+}
+
+// videoCountQuota returns how many videos the user may have, or nil if
+// there's no limit. Trashed videos count until they're purged. On failure it
+// writes the error response and returns false.
+func (cfg *apiConfig) videoCountQuota(w http.ResponseWriter, r *http.Request, userID uuid.UUID) (*int, bool) {
+	user, err := cfg.db.WithContext(r.Context()).GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return nil, false
+	}
+	if user == nil {
+		respondWithError(w, http.StatusUnauthorized, "User not found", nil)
+		return nil, false
+	}
+	return cfg.quotaFor(*user).VideoCount, true
+}
+
+// limitUpload caps the request body at maxSize or the storage the user has
+// left, whichever is smaller, before any of it is read. The asset being
+// replaced counts as free space. On failure it writes the error response and
+// returns false.
+func (cfg *apiConfig) limitUpload(w http.ResponseWriter, r *http.Request, userID uuid.UUID, replacedSize, maxSize int64) bool {
+	usage, quota, ok := cfg.getUsageAndQuota(w, r, userID)
+	if !ok {
+		return false
+	}
+
+	limit := maxSize
+	if quota.StorageBytes != nil {
+		limit = min(limit, *quota.StorageBytes-usage.StorageBytes+replacedSize)
+	}
+	if limit <= 0 {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Storage quota exceeded", nil)
+		return false
+	}
+	if r.ContentLength > limit+multipartOverhead {
+		respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Upload is larger than the %d bytes allowed", limit), nil)
+		return false
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, limit+multipartOverhead)
+	return true
+}
+
+// checkStorageQuota checks the final size of an upload, which limitUpload
+// could only bound, fits in the user's quota. On failure it writes the error
+// response and returns false.
+func (cfg *apiConfig) checkStorageQuota(w http.ResponseWriter, r *http.Request, userID uuid.UUID, replacedSize, newSize int64) bool {
+	usage, quota, ok := cfg.getUsageAndQuota(w, r, userID)
+	if !ok {
+		return false
+	}
+	if quota.StorageBytes != nil && usage.StorageBytes-replacedSize+newSize > *quota.StorageBytes {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Storage quota exceeded", nil)
+		return false
+	}
+	return true
+}
+
+// respondWithUploadError responds 413 if err came from reading past the
+// limit set by limitUpload, and with status and msg otherwise.
+func respondWithUploadError(w http.ResponseWriter, status int, msg string, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Upload is too large", err)
+		return
+	}
+	respondWithError(w, status, msg, err)
+}
+
+func (cfg *apiConfig) handlerUsageGet(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		database.UserUsage
+		Quota database.Quota `json:"quota"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	usage, quota, ok := cfg.getUsageAndQuota(w, r, userID)
+	if !ok {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		UserUsage: usage,
+		Quota:     quota,
+	})
+}
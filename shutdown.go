@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// cancelledRequestGrace is how long requests still running at the shutdown
+// deadline get to notice they've been cancelled and clean up after
+// themselves, for example by removing their temp files. Background jobs get
+// at least as long.
+const cancelledRequestGrace = 5 * time.Second
+
+// lifecycle tracks the work a graceful shutdown has to wait for: in-flight
+// requests, and background jobs such as the trash purger or emails sent
+// after a response.
+type lifecycle struct {
+	requests       sync.WaitGroup
+	requestsCtx    context.Context
+	cancelRequests context.CancelFunc
+
+	jobs sync.WaitGroup
+	// stopping is done once shutdown starts. Jobs that run indefinitely
+	// return when it is; others are left to finish.
+	stopping context.Context
+	stopJobs context.CancelFunc
+}
+
+func newLifecycle() *lifecycle {
+	l := &lifecycle{}
+	l.requestsCtx, l.cancelRequests = context.WithCancel(context.Background())
+	l.stopping, l.stopJobs = context.WithCancel(context.Background())
+	return l
+}
+
+// trackRequests counts requests in and out so shutdown can wait for the
+// ones a forced cancellation interrupted.
+func (l *lifecycle) trackRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l.requests.Add(1)
+		defer l.requests.Done()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// goJob runs fn in the background. Shutdown waits for it to return.
+func (l *lifecycle) goJob(fn func()) {
+	l.jobs.Add(1)
+	go func() {
+		defer l.jobs.Done()
+		fn()
+	}()
+}
+
+// serve runs srv until ctx is done, then shuts it down: it stops accepting
+// connections and waits up to timeout for in-flight requests and background
+// jobs. Requests still running at the deadline are cancelled and their
+// connections closed, which kills any ffmpeg they started.
+func (cfg *apiConfig) serve(ctx context.Context, srv *http.Server, timeout time.Duration) error {
+	srv.BaseContext = func(net.Listener) context.Context {
+		return cfg.lifecycle.requestsCtx
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	cfg.logger.Info("Shutting down", "timeout", timeout)
+	cfg.lifecycle.stopJobs()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := srv.Shutdown(shutdownCtx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		cfg.logger.Warn("Cancelling requests still running at the shutdown deadline")
+		// Closing the connections unblocks handlers still reading an upload,
+		// which cancelling their context alone doesn't.
+		cfg.lifecycle.cancelRequests()
+		srv.Close()
+		if !waitFor(&cfg.lifecycle.requests, cancelledRequestGrace) {
+			cfg.logger.Error("Requests didn't finish after being cancelled")
+		}
+	} else if err != nil {
+		return err
+	}
+	cfg.lifecycle.cancelRequests()
+
+	// Jobs were told to stop when shutdown started, so even past the
+	// deadline they get a moment to return.
+	if !waitFor(&cfg.lifecycle.jobs, max(time.Until(deadlineOf(shutdownCtx)), cancelledRequestGrace)) {
+		cfg.logger.Error("Background jobs didn't finish before the shutdown deadline")
+	}
+	return nil
+}
+
+func deadlineOf(ctx context.Context) time.Time {
+	deadline, _ := ctx.Deadline()
+	return deadline
+}
+
+// waitFor waits for wg for up to timeout, reporting whether it finished.
+func waitFor(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(max(timeout, 0))
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}